@@ -18,9 +18,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
-	"regexp"
-	"strconv"
-	"strings"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -34,37 +32,24 @@ type JobMetrics struct {
 	suspended   float64
 }
 
-func ParseAccountMetrics(input []byte) map[string]*JobMetrics {
+func ParseAccountMetrics(jobs []Job) map[string]*JobMetrics {
 	accounts := make(map[string]*JobMetrics)
 
-	var (
-		pending   = regexp.MustCompile(`^pending`)
-		running   = regexp.MustCompile(`^running`)
-		suspended = regexp.MustCompile(`^suspended`)
-	)
-
-	for _, line := range SplitLines(input) {
-		if strings.Contains(line, "|") {
-			parts := strings.Split(line, "|")
-
-			account := parts[1]
-			_, key := accounts[account]
-			if !key {
-				accounts[account] = &JobMetrics{}
-			}
-			state := strings.ToLower(parts[2])
-			cpus, _ := strconv.ParseFloat(parts[3], 64)
-
-			switch {
-			case pending.MatchString(state) == true:
-				accounts[account].pending++
-				accounts[account].pendingCpus += cpus
-			case running.MatchString(state) == true:
-				accounts[account].running++
-				accounts[account].runningCpus += cpus
-			case suspended.MatchString(state) == true:
-				accounts[account].suspended++
-			}
+	for _, job := range jobs {
+		_, key := accounts[job.account]
+		if !key {
+			accounts[job.account] = &JobMetrics{}
+		}
+
+		switch job.state {
+		case "PENDING":
+			accounts[job.account].pending += job.count()
+			accounts[job.account].pendingCpus += job.cpus * job.tasks()
+		case "RUNNING":
+			accounts[job.account].running += job.count()
+			accounts[job.account].runningCpus += job.cpus
+		case "SUSPENDED":
+			accounts[job.account].suspended += job.count()
 		}
 	}
 	return accounts
@@ -76,6 +61,7 @@ type AccountCollector struct {
 	running     *prometheus.Desc
 	runningCpus *prometheus.Desc
 	suspended   *prometheus.Desc
+	client      SlurmClient
 	logger      log.Logger
 }
 
@@ -83,8 +69,9 @@ func init() {
 	registerCollector("account", defaultEnabled, NewAccountCollector)
 }
 
-func NewAccountCollector(logger log.Logger) (Collector, error) {
+func NewAccountCollector(logger log.Logger, client SlurmClient) (Collector, error) {
 	return &AccountCollector{
+		client:      client,
 		logger:      logger,
 		pending:     prometheus.NewDesc("slurm_account_jobs_pending", "Pending jobs for account", []string{"account"}, nil),
 		pendingCpus: prometheus.NewDesc("slurm_account_cpus_pending", "Pending jobs for account", []string{"account"}, nil),
@@ -94,13 +81,13 @@ func NewAccountCollector(logger log.Logger) (Collector, error) {
 	}, nil
 }
 
-func (ac *AccountCollector) Collect(ch chan<- prometheus.Metric) error {
-	out, err := RunCommand("squeue", "-a", "-r", "-h", "-o %A|%a|%T|%C")
+func (ac *AccountCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	jobs, err := ac.client.Jobs(ctx)
 	if err != nil {
 		return err
 	}
 
-	am := ParseAccountMetrics(out)
+	am := ParseAccountMetrics(jobs)
 	for a := range am {
 		if am[a].pending > 0 {
 			ch <- prometheus.MustNewConstMetric(ac.pending, prometheus.GaugeValue, am[a].pending, a)
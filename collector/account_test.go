@@ -11,7 +11,7 @@ func TestAccountMetrics(t *testing.T) {
 	// Read the input data from a file
 	file, _ := os.Open("fixtures/squeue/account.txt")
 	data, _ := io.ReadAll(file)
-	accounts := ParseAccountMetrics(data)
+	accounts := ParseAccountMetrics(ParseJobs(data))
 
 	assert.Equal(t, 35.0, accounts["ampere"].pending, "Miscount of pending account jobs")
 	assert.Equal(t, 152.0, accounts["ampere"].pendingCpus, "Miscount of cpusPending account jobs")
@@ -19,3 +19,14 @@ func TestAccountMetrics(t *testing.T) {
 	assert.Equal(t, 269.0, accounts["ampere"].runningCpus, "Miscount of runningCpus account jobs")
 	assert.Equal(t, 0.0, accounts["ampere"].suspended, "Miscount of suspended account jobs")
 }
+
+func TestAccountMetricsHeterogeneous(t *testing.T) {
+	data, err := os.ReadFile("fixtures/squeue/het.txt")
+	assert.NoError(t, err)
+	accounts := ParseAccountMetrics(ParseJobs(data))
+
+	assert.Equal(t, 1.0, accounts["account1"].running, "Miscount of running heterogeneous jobs")
+	assert.Equal(t, 12.0, accounts["account1"].runningCpus, "Miscount of running heterogeneous CPUs")
+	assert.Equal(t, 2.0, accounts["account2"].pending, "Miscount of pending heterogeneous jobs")
+	assert.Equal(t, 9.0, accounts["account2"].pendingCpus, "Miscount of pending heterogeneous CPUs")
+}
@@ -0,0 +1,480 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	slurmBackend = kingpin.Flag("slurm.backend", "Where to read the Slurm data from, either the command line tools (cli) or slurmrestd (rest).").Default("cli").Enum("cli", "rest")
+	slurmOutput  = kingpin.Flag("slurm.output", "Output the command line tools are asked for: text, json (Slurm 21.08 or newer) or auto to use json whenever the installed Slurm supports it.").Default("auto").Enum("auto", "text", "json")
+
+	slurmClusters = kingpin.Flag("slurm.cluster", "Cluster to collect, either its name to run the commands with -M, or name:/path/to/slurm.conf to run them with SLURM_CONF. Repeat for several clusters, the metrics then have a cluster label.").Strings()
+
+	clientsMtx sync.Mutex
+	clients    = make(map[string]SlurmClient)
+	cliMtx     sync.Mutex
+	cliClients = make(map[string]*cliClient)
+)
+
+// SlurmClient loads the datasets the collectors are built on. Collectors
+// receive it through their factory, so they can be driven by any backend,
+// including fixtures in tests.
+type SlurmClient interface {
+	// Jobs returns all jobs known to the controller.
+	Jobs(ctx context.Context) ([]Job, error)
+	// Nodes returns all nodes, sorted by name.
+	Nodes(ctx context.Context) ([]Node, error)
+	// Diag returns the scheduler statistics.
+	Diag(ctx context.Context) (*SchedulerMetrics, error)
+	// Shares returns the fairshare of the accounts.
+	Shares(ctx context.Context) (map[string]*FairShareMetrics, error)
+	// EndedJobs returns the jobs which ended between start and end, in any
+	// of the endedJobStates.
+	EndedJobs(ctx context.Context, start, end time.Time) ([]EndedJob, error)
+	// StartedJobs returns the jobs which were running between start and end.
+	StartedJobs(ctx context.Context, start, end time.Time) ([]StartedJob, error)
+	// JobUsage returns what the jobs which ended between start and end
+	// requested and used.
+	JobUsage(ctx context.Context, start, end time.Time) ([]JobUsage, error)
+	// NodeReasons returns the nodes which are down, drained or failing,
+	// with the reason why.
+	NodeReasons(ctx context.Context) ([]NodeReason, error)
+	// NodeDetails returns the load and memory of the nodes, sorted by name.
+	NodeDetails(ctx context.Context) ([]NodeDetails, error)
+}
+
+// cluster is a Slurm cluster given with --slurm.cluster. The cluster the
+// exporter runs in has no name.
+type cluster struct {
+	name string
+	// conf is the slurm.conf of the cluster, without it the commands are
+	// run with -M name
+	conf string
+}
+
+// configuredClusters returns the clusters given with --slurm.cluster, or the
+// unnamed cluster if there are none.
+func configuredClusters() ([]cluster, error) {
+	if len(*slurmClusters) == 0 {
+		return []cluster{{}}, nil
+	}
+	if *slurmBackend == "rest" {
+		return nil, errors.New("--slurm.cluster is not supported by the rest backend")
+	}
+
+	var clusters []cluster
+	seen := make(map[string]bool)
+	for _, value := range *slurmClusters {
+		name, conf, _ := strings.Cut(value, ":")
+		if name == "" {
+			return nil, fmt.Errorf("invalid cluster %q", value)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate cluster %q", name)
+		}
+		seen[name] = true
+		clusters = append(clusters, cluster{name: name, conf: conf})
+	}
+	return clusters, nil
+}
+
+// clientFor returns the client selected with --slurm.backend for a cluster.
+func clientFor(c cluster) (SlurmClient, error) {
+	clientsMtx.Lock()
+	defer clientsMtx.Unlock()
+	if client, ok := clients[c.name]; ok {
+		return client, nil
+	}
+
+	var client SlurmClient
+	var err error
+	switch *slurmBackend {
+	case "rest":
+		client, err = newRestClient()
+	default:
+		client, err = commandLine(c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create %s backend: %w", *slurmBackend, err)
+	}
+	clients[c.name] = client
+	return client, nil
+}
+
+// commandLine returns the client running the Slurm command line tools for a
+// cluster. Replay and record directories have a subdirectory per named
+// cluster.
+func commandLine(c cluster) (*cliClient, error) {
+	cliMtx.Lock()
+	defer cliMtx.Unlock()
+	if cli, ok := cliClients[c.name]; ok {
+		return cli, nil
+	}
+
+	cli := &cliClient{output: *slurmOutput, run: runOutput}
+	if c.conf != "" {
+		cli.env = []string{"SLURM_CONF=" + c.conf}
+	} else {
+		cli.cluster = c.name
+	}
+	switch {
+	case *replayDir != "" && *recordDir != "":
+		return nil, errors.New("--slurm.replay-dir and --slurm.record-dir can't be combined")
+	case *replayDir != "":
+		r, err := newReplayer(filepath.Join(*replayDir, c.name))
+		if err != nil {
+			return nil, err
+		}
+		cli.run = r.run
+	case *recordDir != "":
+		cli.run = newRecorder(filepath.Join(*recordDir, c.name), *recordScrub).run
+	}
+	cliClients[c.name] = cli
+	return cli, nil
+}
+
+// customClient returns a client running the commands with the paths and
+// environment of a collector's configuration. They only apply to the
+// command line tools, other clients are returned as they are.
+func customClient(client SlurmClient, config CollectorConfig) SlurmClient {
+	base, ok := client.(*cliClient)
+	if !ok {
+		return client
+	}
+
+	custom := &cliClient{
+		output:   base.output,
+		cluster:  base.cluster,
+		env:      append([]string{}, base.env...),
+		run:      base.run,
+		commands: config.Commands,
+	}
+	names := make([]string, 0, len(config.Env))
+	for name := range config.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		custom.env = append(custom.env, name+"="+config.Env[name])
+	}
+	return custom
+}
+
+// runFunc runs a Slurm command with additional environment variables and
+// returns its output, see RunCommand.
+type runFunc func(ctx context.Context, env []string, executable string, arguments ...string) ([]byte, error)
+
+// slurmVersion is a Slurm release, e.g. 21.08.
+type slurmVersion struct {
+	major, minor int
+}
+
+func (v slurmVersion) atLeast(major, minor int) bool {
+	return v.major > major || v.major == major && v.minor >= minor
+}
+
+// parseSlurmVersion parses the output of sinfo --version, e.g. "slurm 21.08.5".
+func parseSlurmVersion(input []byte) (slurmVersion, error) {
+	fields := strings.Fields(string(input))
+	if len(fields) == 0 {
+		return slurmVersion{}, fmt.Errorf("empty version")
+	}
+	parts := strings.Split(fields[len(fields)-1], ".")
+	if len(parts) < 2 {
+		return slurmVersion{}, fmt.Errorf("invalid version %q", fields[len(fields)-1])
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return slurmVersion{}, fmt.Errorf("invalid version %q: %w", fields[len(fields)-1], err)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return slurmVersion{}, fmt.Errorf("invalid version %q: %w", fields[len(fields)-1], err)
+	}
+	return slurmVersion{major: major, minor: minor}, nil
+}
+
+// cliClient runs the Slurm command line tools.
+type cliClient struct {
+	// output is either text, json or auto
+	output string
+	// cluster is passed to the commands with -M
+	cluster string
+	env     []string
+	run     runFunc
+	// commands maps the Slurm commands to the paths they are run from
+	commands map[string]string
+
+	mtx     sync.Mutex
+	version *slurmVersion
+}
+
+// installedVersion asks sinfo for the Slurm release, until it succeeds once.
+func (c *cliClient) installedVersion(ctx context.Context) (slurmVersion, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.version != nil {
+		return *c.version, nil
+	}
+
+	out, err := c.run(ctx, c.env, c.executable("sinfo"), "--version")
+	if err != nil {
+		return slurmVersion{}, err
+	}
+	version, err := parseSlurmVersion(out)
+	if err != nil {
+		return slurmVersion{}, err
+	}
+	c.version = &version
+	return version, nil
+}
+
+// knownVersion returns the Slurm release if installedVersion found it,
+// without running sinfo.
+func (c *cliClient) knownVersion() (slurmVersion, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.version == nil {
+		return slurmVersion{}, false
+	}
+	return *c.version, true
+}
+
+// useJSON reports whether the tools are asked for JSON, which they support
+// since Slurm 21.08. The installed version is only returned in that case.
+func (c *cliClient) useJSON(ctx context.Context) (bool, slurmVersion, error) {
+	if c.output == "text" {
+		return false, slurmVersion{}, nil
+	}
+	version, err := c.installedVersion(ctx)
+	if err != nil {
+		return false, version, err
+	}
+	return c.output == "json" || version.atLeast(21, 8), version, nil
+}
+
+// command runs a Slurm command against the cluster of the client.
+func (c *cliClient) command(ctx context.Context, executable string, arguments ...string) ([]byte, error) {
+	if c.cluster != "" {
+		arguments = append([]string{"-M", c.cluster}, arguments...)
+	}
+	return c.run(ctx, c.env, c.executable(executable), arguments...)
+}
+
+// executable returns the path a Slurm command is run from.
+func (c *cliClient) executable(command string) string {
+	if path, ok := c.commands[command]; ok {
+		return path
+	}
+	return command
+}
+
+func (c *cliClient) Jobs(ctx context.Context) ([]Job, error) {
+	asJSON, _, err := c.useJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if asJSON {
+		out, err := c.command(ctx, "squeue", "-a", "--states=all", "--json")
+		if err != nil {
+			return nil, err
+		}
+		return ParseJobsJSON(out)
+	}
+
+	out, err := c.command(ctx, "squeue", "-a", "-h", "--states=all", "-o", squeueFormat)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJobs(out), nil
+}
+
+func (c *cliClient) Nodes(ctx context.Context) ([]Node, error) {
+	asJSON, version, err := c.useJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if asJSON {
+		// since 23.02 sinfo groups the nodes in its JSON output like it
+		// does in text, scontrol still lists every node on its own
+		args := []string{"sinfo", "-a", "--json"}
+		if version.atLeast(23, 2) {
+			args = []string{"scontrol", "--json", "show", "nodes"}
+		}
+		out, err := c.command(ctx, args[0], args[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		return ParseNodesJSON(out)
+	}
+
+	// StateComplete is known to sinfo since 21.08, it is only asked for
+	// when the version was already probed, which --slurm.output=text skips
+	format := sinfoFormat
+	if version, ok := c.knownVersion(); ok && version.atLeast(21, 8) {
+		format += sinfoStateComplete
+	}
+	out, err := c.command(ctx, "sinfo", "-h", "-a", "-N", "-O", format)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodes(out), nil
+}
+
+func (c *cliClient) NodeReasons(ctx context.Context) ([]NodeReason, error) {
+	// sinfo ignores -R with --json, the reasons are only listed in text
+	out, err := c.command(ctx, "sinfo", "-h", "-R", "-o", sinfoReasonFormat)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodeReasons(out), nil
+}
+
+func (c *cliClient) NodeDetails(ctx context.Context) ([]NodeDetails, error) {
+	asJSON, version, err := c.useJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// scontrol prints JSON since 23.02, like for Nodes
+	if asJSON && version.atLeast(23, 2) {
+		out, err := c.command(ctx, "scontrol", "--json", "show", "nodes")
+		if err != nil {
+			return nil, err
+		}
+		return ParseNodeDetailsJSON(out)
+	}
+
+	out, err := c.command(ctx, "scontrol", "show", "node", "-o")
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodeDetails(out), nil
+}
+
+func (c *cliClient) Diag(ctx context.Context) (*SchedulerMetrics, error) {
+	asJSON, _, err := c.useJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if asJSON {
+		out, err := c.command(ctx, "sdiag", "--json")
+		if err != nil {
+			return nil, err
+		}
+		return ParseSchedulerMetricsJSON(out)
+	}
+
+	out, err := c.command(ctx, "sdiag")
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchedulerMetrics(out), nil
+}
+
+func (c *cliClient) Shares(ctx context.Context) (map[string]*FairShareMetrics, error) {
+	// sshare has no JSON output
+	out, err := c.command(ctx, "sshare", "-n", "-P", "-o", "account,fairshare")
+	if err != nil {
+		return nil, err
+	}
+	return ParseFairShareMetrics(out), nil
+}
+
+func (c *cliClient) EndedJobs(ctx context.Context, start, end time.Time) ([]EndedJob, error) {
+	asJSON, _, err := c.useJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--state=" + endedJobStates,
+		"-S" + start.Format("2006-01-02T15:04:05"),
+		"-E" + end.Format("2006-01-02T15:04:05"),
+		"-X", "-a"}
+	if asJSON {
+		out, err := c.command(ctx, "sacct", append(args, "--json")...)
+		if err != nil {
+			return nil, err
+		}
+		return ParseEndedJobsJSON(out)
+	}
+
+	out, err := c.command(ctx, "sacct", append(args, "-n", "-P", "--format="+sacctFormat)...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEndedJobs(out), nil
+}
+
+func (c *cliClient) StartedJobs(ctx context.Context, start, end time.Time) ([]StartedJob, error) {
+	asJSON, _, err := c.useJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--state=RUNNING",
+		"-S" + start.Format("2006-01-02T15:04:05"),
+		"-E" + end.Format("2006-01-02T15:04:05"),
+		"-X", "-a"}
+	if asJSON {
+		out, err := c.command(ctx, "sacct", append(args, "--json")...)
+		if err != nil {
+			return nil, err
+		}
+		return ParseStartedJobsJSON(out)
+	}
+
+	out, err := c.command(ctx, "sacct", append(args, "-n", "-P", "--format="+sacctStartedFormat)...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStartedJobs(out), nil
+}
+
+func (c *cliClient) JobUsage(ctx context.Context, start, end time.Time) ([]JobUsage, error) {
+	asJSON, _, err := c.useJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// without -X, sacct lists the steps, which hold the memory used
+	args := []string{"--state=" + endedJobStates,
+		"-S" + start.Format("2006-01-02T15:04:05"),
+		"-E" + end.Format("2006-01-02T15:04:05"),
+		"-a"}
+	if asJSON {
+		out, err := c.command(ctx, "sacct", append(args, "--json")...)
+		if err != nil {
+			return nil, err
+		}
+		return ParseJobUsageJSON(out)
+	}
+
+	out, err := c.command(ctx, "sacct", append(args, "-n", "-P", "--format="+sacctUsageFormat)...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJobUsage(out), nil
+}
@@ -0,0 +1,204 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSlurmVersion(t *testing.T) {
+	version, err := parseSlurmVersion([]byte("slurm 21.08.5\n"))
+	require.NoError(t, err)
+	assert.Equal(t, slurmVersion{major: 21, minor: 8}, version)
+	assert.True(t, version.atLeast(21, 8))
+	assert.False(t, version.atLeast(23, 2))
+
+	version, err = parseSlurmVersion([]byte("slurm-wlm 23.11.4"))
+	require.NoError(t, err)
+	assert.True(t, version.atLeast(23, 2))
+
+	_, err = parseSlurmVersion([]byte(""))
+	assert.Error(t, err)
+}
+
+// fixtureClient serves the text fixtures, to drive collectors end to end.
+type fixtureClient struct {
+	jobs, nodes, diag, shares, endedJobs, startedJobs, jobUsage, nodeReasons, nodeDetails string
+}
+
+func (c fixtureClient) read(name string) ([]byte, error) {
+	if name == "" {
+		return nil, errors.New("no fixture")
+	}
+	return os.ReadFile(name)
+}
+
+func (c fixtureClient) Jobs(ctx context.Context) ([]Job, error) {
+	data, err := c.read(c.jobs)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJobs(data), nil
+}
+
+func (c fixtureClient) Nodes(ctx context.Context) ([]Node, error) {
+	data, err := c.read(c.nodes)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodes(data), nil
+}
+
+func (c fixtureClient) Diag(ctx context.Context) (*SchedulerMetrics, error) {
+	data, err := c.read(c.diag)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchedulerMetrics(data), nil
+}
+
+func (c fixtureClient) Shares(ctx context.Context) (map[string]*FairShareMetrics, error) {
+	data, err := c.read(c.shares)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFairShareMetrics(data), nil
+}
+
+func (c fixtureClient) EndedJobs(ctx context.Context, start, end time.Time) ([]EndedJob, error) {
+	data, err := c.read(c.endedJobs)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEndedJobs(data), nil
+}
+
+func (c fixtureClient) StartedJobs(ctx context.Context, start, end time.Time) ([]StartedJob, error) {
+	data, err := c.read(c.startedJobs)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStartedJobs(data), nil
+}
+
+func (c fixtureClient) JobUsage(ctx context.Context, start, end time.Time) ([]JobUsage, error) {
+	data, err := c.read(c.jobUsage)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJobUsage(data), nil
+}
+
+func (c fixtureClient) NodeDetails(ctx context.Context) ([]NodeDetails, error) {
+	data, err := c.read(c.nodeDetails)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodeDetails(data), nil
+}
+
+func (c fixtureClient) NodeReasons(ctx context.Context) ([]NodeReason, error) {
+	data, err := c.read(c.nodeReasons)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodeReasons(data), nil
+}
+
+// registryCollector adapts a Collector to a prometheus.Collector, so the
+// emitted metrics can be compared with testutil.
+type registryCollector struct {
+	Collector
+	err error
+}
+
+func (c *registryCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *registryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.err = c.Collector.Collect(context.Background(), ch)
+}
+
+func TestCollectorWithoutData(t *testing.T) {
+	qc, err := NewQueueCollector(log.NewNopLogger(), fixtureClient{})
+	require.NoError(t, err)
+
+	ch := make(chan prometheus.Metric, 1)
+	assert.Error(t, qc.Collect(context.Background(), ch))
+	assert.Empty(t, ch)
+}
+
+func TestConfiguredClusters(t *testing.T) {
+	defer func(values []string) { *slurmClusters = values }(*slurmClusters)
+
+	*slurmClusters = nil
+	clusters, err := configuredClusters()
+	require.NoError(t, err)
+	assert.Equal(t, []cluster{{}}, clusters)
+
+	*slurmClusters = []string{"alpha", "beta:/etc/slurm/beta.conf"}
+	clusters, err = configuredClusters()
+	require.NoError(t, err)
+	assert.Equal(t, []cluster{{name: "alpha"}, {name: "beta", conf: "/etc/slurm/beta.conf"}}, clusters)
+
+	*slurmClusters = []string{"alpha", "alpha"}
+	_, err = configuredClusters()
+	assert.ErrorContains(t, err, "duplicate cluster")
+}
+
+func TestCLIClientCluster(t *testing.T) {
+	var commands [][]string
+	run := func(ctx context.Context, env []string, executable string, arguments ...string) ([]byte, error) {
+		commands = append(commands, append(append(env, executable), arguments...))
+		return readFixture(t, "fixtures/sdiag/sdiag.txt"), nil
+	}
+
+	_, err := (&cliClient{output: "text", cluster: "alpha", run: run}).Diag(context.Background())
+	require.NoError(t, err)
+	_, err = (&cliClient{output: "text", env: []string{"SLURM_CONF=/etc/slurm/beta.conf"}, run: run}).Diag(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"sdiag", "-M", "alpha"}, {"SLURM_CONF=/etc/slurm/beta.conf", "sdiag"}}, commands)
+}
+
+func TestCLIClientStateComplete(t *testing.T) {
+	var probed bool
+	var format string
+	newClient := func(output, version string) *cliClient {
+		probed, format = false, ""
+		run := func(ctx context.Context, env []string, executable string, arguments ...string) ([]byte, error) {
+			if arguments[0] == "--version" {
+				probed = true
+				return []byte("slurm " + version), nil
+			}
+			format = arguments[len(arguments)-1]
+			return readFixture(t, "fixtures/sinfo/states.txt"), nil
+		}
+		return &cliClient{output: output, run: run}
+	}
+
+	// the text output does not ask for the version
+	_, err := newClient("text", "21.08.5").Nodes(context.Background())
+	require.NoError(t, err)
+	assert.False(t, probed)
+	assert.Equal(t, sinfoFormat, format)
+
+	c := newClient("text", "21.08.5")
+	c.version = &slurmVersion{21, 8}
+	_, err = c.Nodes(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, sinfoFormat+sinfoStateComplete, format)
+
+	// older releases are asked in text by the auto output
+	_, err = newClient("auto", "20.11.8").Nodes(context.Background())
+	require.NoError(t, err)
+	assert.True(t, probed)
+	assert.Equal(t, sinfoFormat, format)
+}
@@ -15,11 +15,15 @@
 package collector
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -44,22 +48,45 @@ var (
 		[]string{"collector"},
 		nil,
 	)
+	scrapeTimeoutDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_timeout"),
+		"slurm_exporter: Whether a collector was aborted because it exceeded its timeout.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeLastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_last_success_timestamp_seconds"),
+		"slurm_exporter: Unix timestamp of the last successful run of a collector.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeCacheAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_cache_age_seconds"),
+		"slurm_exporter: Age of the cached metrics served by a background collector.",
+		[]string{"collector"},
+		nil,
+	)
 )
 
 const (
 	defaultEnabled  = true
 	defaultDisabled = false
+	defaultTimeout  = "30s"
 )
 
 var (
-	factories              = make(map[string]func(logger log.Logger) (Collector, error))
+	factories              = make(map[string]func(logger log.Logger, client SlurmClient) (Collector, error))
 	initiatedCollectorsMtx = sync.Mutex{}
-	initiatedCollectors    = make(map[string]Collector)
+	initiatedCollectors    = make(map[collectorKey]Collector)
 	collectorState         = make(map[string]*bool)
+	collectorTimeout       = make(map[string]*time.Duration)
+	collectorInterval      = make(map[string]*time.Duration)
+	lastSuccessMtx         = sync.Mutex{}
+	lastSuccess            = make(map[collectorKey]time.Time)
 	forcedCollectors       = map[string]bool{} // collectors which have been explicitly enabled or disabled
 )
 
-func registerCollector(collector string, isDefaultEnabled bool, factory func(logger log.Logger) (Collector, error)) {
+func registerCollector(collector string, isDefaultEnabled bool, factory func(logger log.Logger, client SlurmClient) (Collector, error)) {
 	var helpDefaultState string
 	if isDefaultEnabled {
 		helpDefaultState = "enabled"
@@ -74,12 +101,21 @@ func registerCollector(collector string, isDefaultEnabled bool, factory func(log
 	flag := kingpin.Flag(flagName, flagHelp).Default(defaultValue).Action(collectorFlagAction(collector)).Bool()
 	collectorState[collector] = flag
 
+	timeoutFlagName := fmt.Sprintf("collector.%s.timeout", collector)
+	timeoutFlagHelp := fmt.Sprintf("Timeout for the Slurm commands run by the %s collector. Use 0 to disable.", collector)
+	collectorTimeout[collector] = kingpin.Flag(timeoutFlagName, timeoutFlagHelp).Default(defaultTimeout).Duration()
+
+	intervalFlagName := fmt.Sprintf("collector.%s.interval", collector)
+	intervalFlagHelp := fmt.Sprintf("Refresh the %s collector in the background on this interval and serve the cached metrics on scrape. Use 0 to collect on every scrape.", collector)
+	collectorInterval[collector] = kingpin.Flag(intervalFlagName, intervalFlagHelp).Default("0").Duration()
+
 	factories[collector] = factory
 }
 
 // SlurmCollector implements the prometheus.Collector interface.
 type SlurmCollector struct {
 	Collectors map[string]Collector
+	cluster    string
 	logger     log.Logger
 }
 
@@ -105,84 +141,195 @@ func collectorFlagAction(collector string) func(ctx *kingpin.ParseContext) error
 	}
 }
 
-// NewSlurmCollector creates a new SlurmCollector.
-func NewSlurmCollector(logger log.Logger, filters ...string) (*SlurmCollector, error) {
+// NewSlurmCollectors creates a SlurmCollector for every cluster given with
+// --slurm.cluster, keyed by the cluster name. Without --slurm.cluster, it
+// returns a single SlurmCollector under the empty name.
+func NewSlurmCollectors(logger log.Logger, filters ...string) (map[string]*SlurmCollector, error) {
 	f := make(map[string]bool)
 	for _, filter := range filters {
-		enabled, exist := collectorState[filter]
-		if !exist {
+		if _, exist := collectorState[filter]; !exist {
 			return nil, fmt.Errorf("missing collector: %s", filter)
 		}
-		if !*enabled {
+		if !isEnabled(filter) {
 			return nil, fmt.Errorf("disabled collector: %s", filter)
 		}
 		f[filter] = true
 	}
+	clusters, err := configuredClusters()
+	if err != nil {
+		return nil, err
+	}
+	slurmCollectors := make(map[string]*SlurmCollector)
+	for _, cluster := range clusters {
+		sc, err := newSlurmCollector(logger, cluster, f)
+		if err != nil {
+			return nil, err
+		}
+		slurmCollectors[cluster.name] = sc
+	}
+	return slurmCollectors, nil
+}
+
+func newSlurmCollector(logger log.Logger, cluster cluster, filters map[string]bool) (*SlurmCollector, error) {
+	client, err := clientFor(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.name != "" {
+		logger = log.With(logger, "cluster", cluster.name)
+	}
 	collectors := make(map[string]Collector)
 	initiatedCollectorsMtx.Lock()
 	defer initiatedCollectorsMtx.Unlock()
-	for key, enabled := range collectorState {
-		if !*enabled || (len(f) > 0 && !f[key]) {
+	for name := range collectorState {
+		if !isEnabled(name) || (len(filters) > 0 && !filters[name]) {
 			continue
 		}
+		key := collectorKey{cluster: cluster.name, name: name}
 		if collector, ok := initiatedCollectors[key]; ok {
-			collectors[key] = collector
+			collectors[name] = collector
 		} else {
-			collector, err := factories[key](log.With(logger, "collector", key))
+			collector, err := createCollector(name, cluster.name, client, logger)
 			if err != nil {
 				return nil, err
 			}
-			collectors[key] = collector
+			if interval := intervalOf(name); interval > 0 {
+				collector = newPollingCollector(key, collector, interval, logger)
+			}
+			collectors[name] = collector
 			initiatedCollectors[key] = collector
 		}
 	}
-	return &SlurmCollector{Collectors: collectors, logger: logger}, nil
+	return &SlurmCollector{Collectors: collectors, cluster: cluster.name, logger: logger}, nil
+}
+
+// createCollector calls the factory of a collector and applies the settings
+// of the configuration file.
+func createCollector(name string, cluster string, client SlurmClient, logger log.Logger) (Collector, error) {
+	config := collectorConfig(name)
+	shared := sharedClient{SlurmClient: client, cluster: cluster}
+	if len(config.Commands) > 0 || len(config.Env) > 0 {
+		// the collector runs its own commands, so it can't share their output
+		shared = sharedClient{SlurmClient: customClient(client, config), prefix: name + "/", cluster: cluster}
+	}
+
+	collector, err := factories[name](log.With(logger, "collector", name), shared)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Labels) > 0 {
+		collector = labelledCollector{collector: collector, labels: config.Labels}
+	}
+	return collector, nil
 }
 
 // Describe implements the prometheus.Collector interface.
 func (n SlurmCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- scrapeTimeoutDesc
+	ch <- scrapeLastSuccessDesc
+	ch <- scrapeCacheAgeDesc
 }
 
 // Collect implements the prometheus.Collector interface.
 func (n SlurmCollector) Collect(ch chan<- prometheus.Metric) {
+	// All collectors of this scrape share one snapshot of the Slurm data,
+	// its pending fetches are cancelled once every collector has returned.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = withSnapshot(ctx, newSnapshot(ctx))
+
 	wg := sync.WaitGroup{}
 	wg.Add(len(n.Collectors))
 	for name, c := range n.Collectors {
 		go func(name string, c Collector) {
-			execute(name, c, ch, n.logger)
+			if p, ok := c.(*pollingCollector); ok {
+				p.serve(ch)
+			} else {
+				execute(ctx, collectorKey{cluster: n.cluster, name: name}, c, ch, n.logger)
+			}
 			wg.Done()
 		}(name, c)
 	}
 	wg.Wait()
 }
 
-func execute(name string, c Collector, ch chan<- prometheus.Metric, logger log.Logger) {
+// timeoutContext returns a context bound to the configured timeout of the
+// named collector, or a plain cancellable context if it has none.
+func timeoutContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	if timeout := timeoutOf(name); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// collectorKey identifies a collector of a cluster, the cluster is empty
+// unless --slurm.cluster is given.
+type collectorKey struct {
+	cluster string
+	name    string
+}
+
+func execute(ctx context.Context, key collectorKey, c Collector, ch chan<- prometheus.Metric, logger log.Logger) {
+	duration, err := run(ctx, key, c, ch, logger)
+	sendStatus(ch, key, duration, err)
+}
+
+// run collects the metrics of a single collector within its timeout, logs
+// the outcome and keeps track of the last successful run.
+func run(ctx context.Context, key collectorKey, c Collector, ch chan<- prometheus.Metric, logger log.Logger) (time.Duration, error) {
+	name := key.name
+	ctx, cancel := timeoutContext(ctx, name)
+	defer cancel()
+
 	begin := time.Now()
-	err := c.Collect(ch)
+	err := c.Collect(ctx, ch)
 	duration := time.Since(begin)
-	var success float64
 
 	if err != nil {
 		if IsNoDataError(err) {
 			level.Debug(logger).Log("msg", "collector returned no data", "name", name, "duration_seconds", duration.Seconds(), "err", err)
+		} else if IsTimeoutError(err) {
+			level.Error(logger).Log("msg", "collector timed out", "name", name, "duration_seconds", duration.Seconds(), "err", err)
 		} else {
 			level.Error(logger).Log("msg", "collector failed", "name", name, "duration_seconds", duration.Seconds(), "err", err)
 		}
-		success = 0
 	} else {
 		level.Debug(logger).Log("msg", "collector succeeded", "name", name, "duration_seconds", duration.Seconds())
+		lastSuccessMtx.Lock()
+		lastSuccess[key] = time.Now()
+		lastSuccessMtx.Unlock()
+	}
+	return duration, err
+}
+
+// sendStatus reports the outcome of the last run of a collector.
+func sendStatus(ch chan<- prometheus.Metric, key collectorKey, duration time.Duration, err error) {
+	name := key.name
+	var success, timedOut float64
+	if err == nil {
 		success = 1
+	} else if IsTimeoutError(err) {
+		timedOut = 1
 	}
 	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
 	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
+	ch <- prometheus.MustNewConstMetric(scrapeTimeoutDesc, prometheus.GaugeValue, timedOut, name)
+
+	lastSuccessMtx.Lock()
+	last, ok := lastSuccess[key]
+	lastSuccessMtx.Unlock()
+	if ok {
+		ch <- prometheus.MustNewConstMetric(scrapeLastSuccessDesc, prometheus.GaugeValue, float64(last.UnixNano())/1e9, name)
+	}
 }
 
 // Collector is the interface a collector has to implement.
 type Collector interface {
 	// Collect Get new metrics and expose them via prometheus registry.
-	Collect(ch chan<- prometheus.Metric) error
+	// The context is cancelled once the collector exceeds its timeout.
+	Collect(ctx context.Context, ch chan<- prometheus.Metric) error
 }
 
 // ErrNoData indicates the collector found no data to collect, but had no other error.
@@ -192,13 +339,54 @@ func IsNoDataError(err error) bool {
 	return err == ErrNoData
 }
 
-func RunCommand(executable string, arguments ...string) ([]byte, error) {
-	subprocess := exec.Command(executable, arguments...)
-	out, err := subprocess.CombinedOutput()
+// IsTimeoutError reports whether the collector was aborted by its deadline.
+func IsTimeoutError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// RunCommand executes a Slurm command and returns its output. The command is
+// started in its own process group, which is killed as a whole once the
+// context is done, so that no child keeps blocking on a hung slurmctld.
+func RunCommand(ctx context.Context, executable string, arguments ...string) ([]byte, error) {
+	return runOutput(ctx, nil, executable, arguments...)
+}
+
+// runOutput is RunCommand with additional environment variables, e.g.
+// SLURM_CONF.
+func runOutput(ctx context.Context, env []string, executable string, arguments ...string) ([]byte, error) {
+	stdout, _, err := runCommand(ctx, env, executable, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	return stdout, nil
+}
+
+// runCommand is RunCommand returning stderr as well. stderr is kept apart
+// from stdout, warnings printed there would corrupt JSON output.
+func runCommand(ctx context.Context, env []string, executable string, arguments ...string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	subprocess := exec.CommandContext(ctx, executable, arguments...)
+	if len(env) > 0 {
+		subprocess.Env = append(os.Environ(), env...)
+	}
+	subprocess.Stdout = &stdout
+	subprocess.Stderr = &stderr
+	subprocess.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	subprocess.Cancel = func() error {
+		return syscall.Kill(-subprocess.Process.Pid, syscall.SIGKILL)
+	}
+	subprocess.WaitDelay = time.Second
+	err := subprocess.Run()
+	if ctx.Err() != nil {
+		return stdout.Bytes(), stderr.Bytes(), fmt.Errorf("run command %s: %w", executable, ctx.Err())
+	}
+	if message := bytes.TrimSpace(stderr.Bytes()); err != nil && len(message) > 0 {
+		return stdout.Bytes(), stderr.Bytes(), fmt.Errorf("run command error: %w: %s", err, message)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("run command error: %w", err)
+		return stdout.Bytes(), stderr.Bytes(), fmt.Errorf("run command error: %w", err)
 	}
-	return out, nil
+	return stdout.Bytes(), stderr.Bytes(), nil
 }
 
 func SplitLines(input []byte) []string {
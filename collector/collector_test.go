@@ -0,0 +1,27 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommand(t *testing.T) {
+	out, err := RunCommand(context.Background(), "echo", "slurm")
+	assert.NoError(t, err)
+	assert.Equal(t, "slurm\n", string(out))
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The shell forks a child holding the output pipe, which must be killed
+	// together with its parent for the call to return in time.
+	begin := time.Now()
+	_, err := RunCommand(ctx, "sh", "-c", "sleep 10; echo done")
+	assert.True(t, IsTimeoutError(err), "expected timeout error, got %v", err)
+	assert.Less(t, time.Since(begin), 5*time.Second)
+}
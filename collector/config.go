@@ -0,0 +1,334 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of the configuration file. Settings missing from it
+// keep the value of the command line flags.
+type Config struct {
+	Collectors map[string]CollectorConfig `yaml:"collectors"`
+}
+
+// CollectorConfig holds the settings of a single collector.
+type CollectorConfig struct {
+	Enabled  *bool           `yaml:"enabled"`
+	Timeout  *model.Duration `yaml:"timeout"`
+	Interval *model.Duration `yaml:"interval"`
+	// Commands replaces the path of Slurm commands, e.g. squeue: /opt/slurm/bin/squeue
+	Commands map[string]string `yaml:"commands"`
+	// Env is added to the environment of the Slurm commands, e.g. SLURM_CONF
+	Env map[string]string `yaml:"env"`
+	// Labels are added to every metric of the collector
+	Labels map[string]string `yaml:"labels"`
+	// Options are specific to the collector, see registerCollectorOptions
+	Options map[string]interface{} `yaml:"options"`
+}
+
+var (
+	configMtx     sync.RWMutex
+	currentConfig = &Config{}
+
+	// collectorOptions returns the default options of the collectors which
+	// have any, they are decoded from the options of the configuration
+	collectorOptions = make(map[string]func() interface{})
+
+	slurmCommands = map[string]bool{"squeue": true, "sinfo": true, "sdiag": true, "sshare": true, "sacct": true, "scontrol": true}
+)
+
+// registerCollectorOptions declares the options a collector accepts, so they
+// are validated when the configuration is loaded.
+func registerCollectorOptions(collector string, defaults func() interface{}) {
+	collectorOptions[collector] = defaults
+}
+
+// optionsValidator is implemented by the options which need more checks
+// than their types, e.g. the order of histogram buckets.
+type optionsValidator interface {
+	validate() error
+}
+
+// LoadConfig reads and validates the configuration file. On success, it
+// replaces the current configuration and the collectors are created anew.
+func LoadConfig(path string) error {
+	config, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	configMtx.Lock()
+	currentConfig = config
+	configMtx.Unlock()
+	resetCollectors()
+	return nil
+}
+
+// ReloadConfig reads the configuration file again and calls apply, which
+// creates the collectors with it. Only the collectors whose configuration
+// changed are created anew, the others keep their state, e.g. the cursor of
+// the sacct based collectors and their counters. If the file is invalid or
+// apply fails, the previous configuration and collectors stay in place,
+// otherwise the background refresh of the replaced collectors is stopped.
+func ReloadConfig(path string, apply func() error) error {
+	config, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	configMtx.Lock()
+	previous := currentConfig
+	currentConfig = config
+	configMtx.Unlock()
+	changed := func(name string) bool {
+		return !reflect.DeepEqual(previous.Collectors[name], config.Collectors[name])
+	}
+	replaced := takeCollectors(changed)
+
+	if err := apply(); err != nil {
+		configMtx.Lock()
+		currentConfig = previous
+		configMtx.Unlock()
+		restoreCollectors(replaced, changed)
+		return err
+	}
+	stopCollectors(replaced)
+	return nil
+}
+
+func readConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config: %w", err)
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("couldn't parse config: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	names := make([]string, 0, len(c.Collectors))
+	for name := range c.Collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cc := c.Collectors[name]
+		if _, ok := factories[name]; !ok {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+		if cc.Timeout != nil && *cc.Timeout < 0 {
+			return fmt.Errorf("collector %s: negative timeout", name)
+		}
+		if cc.Interval != nil && *cc.Interval < 0 {
+			return fmt.Errorf("collector %s: negative interval", name)
+		}
+		for command := range cc.Commands {
+			if !slurmCommands[command] {
+				return fmt.Errorf("collector %s: unknown command %q", name, command)
+			}
+		}
+		for label := range cc.Labels {
+			if !model.LabelName(label).IsValid() {
+				return fmt.Errorf("collector %s: invalid label name %q", name, label)
+			}
+		}
+		if len(cc.Options) > 0 {
+			if _, ok := collectorOptions[name]; !ok {
+				return fmt.Errorf("collector %s has no options", name)
+			}
+			if err := cc.decodeOptions(collectorOptions[name]()); err != nil {
+				return fmt.Errorf("collector %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (cc CollectorConfig) decodeOptions(v interface{}) error {
+	if len(cc.Options) == 0 {
+		return nil
+	}
+	data, err := yaml.Marshal(cc.Options)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(data, v); err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	if validator, ok := v.(optionsValidator); ok {
+		if err := validator.validate(); err != nil {
+			return fmt.Errorf("invalid options: %w", err)
+		}
+	}
+	return nil
+}
+
+// collectorConfig returns the configuration of a collector, empty if the
+// file does not mention it.
+func collectorConfig(name string) CollectorConfig {
+	configMtx.RLock()
+	defer configMtx.RUnlock()
+	return currentConfig.Collectors[name]
+}
+
+// decodeOptions fills v, which holds the defaults, with the options of the
+// collector from the configuration.
+func decodeOptions(name string, v interface{}) error {
+	return collectorConfig(name).decodeOptions(v)
+}
+
+// isEnabled reports whether a collector is enabled by the configuration or,
+// if it is not set there, by its flag.
+func isEnabled(name string) bool {
+	if enabled := collectorConfig(name).Enabled; enabled != nil {
+		return *enabled
+	}
+	return *collectorState[name]
+}
+
+// timeoutOf returns the timeout of a collector, 0 if it has none.
+func timeoutOf(name string) time.Duration {
+	if timeout := collectorConfig(name).Timeout; timeout != nil {
+		return time.Duration(*timeout)
+	}
+	if timeout, ok := collectorTimeout[name]; ok {
+		return *timeout
+	}
+	return 0
+}
+
+// intervalOf returns the background refresh interval of a collector, 0 if
+// it is collected on every scrape.
+func intervalOf(name string) time.Duration {
+	if interval := collectorConfig(name).Interval; interval != nil {
+		return time.Duration(*interval)
+	}
+	if interval, ok := collectorInterval[name]; ok {
+		return *interval
+	}
+	return 0
+}
+
+// resetCollectors drops the created collectors and stops their background
+// refresh, so that the next NewSlurmCollectors applies a new configuration.
+func resetCollectors() {
+	stopCollectors(takeCollectors(func(string) bool { return true }))
+}
+
+// takeCollectors removes the created collectors with the given names, so
+// that the next NewSlurmCollectors creates them anew, and returns them.
+func takeCollectors(names func(name string) bool) map[collectorKey]Collector {
+	initiatedCollectorsMtx.Lock()
+	defer initiatedCollectorsMtx.Unlock()
+	taken := make(map[collectorKey]Collector)
+	for key, c := range initiatedCollectors {
+		if names(key.name) {
+			taken[key] = c
+			delete(initiatedCollectors, key)
+		}
+	}
+	return taken
+}
+
+// restoreCollectors puts back collectors returned by takeCollectors, the
+// ones created with the same names in the meantime are stopped.
+func restoreCollectors(collectors map[collectorKey]Collector, names func(name string) bool) {
+	stopCollectors(takeCollectors(names))
+	initiatedCollectorsMtx.Lock()
+	defer initiatedCollectorsMtx.Unlock()
+	for key, c := range collectors {
+		initiatedCollectors[key] = c
+	}
+}
+
+// stopCollectors stops the background refresh of collectors.
+func stopCollectors(collectors map[collectorKey]Collector) {
+	for _, c := range collectors {
+		if p, ok := c.(*pollingCollector); ok {
+			p.stop()
+		}
+	}
+}
+
+// labelledCollector adds constant labels to the metrics of a collector.
+type labelledCollector struct {
+	collector Collector
+	labels    prometheus.Labels
+}
+
+func (l labelledCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// the wrapping registerer relabels the metrics of the collectors it
+	// registers, it is used here to obtain such a wrapped collector
+	inner := &contextCollector{ctx: ctx, collector: l.collector}
+	var capture capturingRegisterer
+	if err := prometheus.WrapRegistererWith(l.labels, &capture).Register(inner); err != nil {
+		return err
+	}
+	capture.collector.Collect(ch)
+	return inner.err
+}
+
+// contextCollector runs a Collector as a prometheus.Collector.
+type contextCollector struct {
+	ctx       context.Context
+	collector Collector
+	err       error
+}
+
+func (c *contextCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.err = c.collector.Collect(c.ctx, ch)
+}
+
+// capturingRegisterer keeps the collector it is asked to register.
+type capturingRegisterer struct {
+	collector prometheus.Collector
+}
+
+func (r *capturingRegisterer) Register(c prometheus.Collector) error {
+	r.collector = c
+	return nil
+}
+
+func (r *capturingRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		r.collector = c
+	}
+}
+
+func (r *capturingRegisterer) Unregister(c prometheus.Collector) bool {
+	return false
+}
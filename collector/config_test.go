@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadTestConfig loads a configuration and restores the previous one once
+// the test is done.
+func loadTestConfig(t *testing.T, content string) error {
+	previous := currentConfig
+	t.Cleanup(func() {
+		currentConfig = previous
+		resetCollectors()
+	})
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return LoadConfig(path)
+}
+
+func TestLoadConfig(t *testing.T) {
+	require.NoError(t, loadTestConfig(t, string(readFixture(t, "../examples/config/slurm_exporter.yml"))))
+
+	assert.True(t, isEnabled("job"))
+	assert.Equal(t, time.Minute, timeoutOf("job"))
+	assert.Equal(t, 5*time.Minute, intervalOf("job"))
+	assert.Equal(t, 10*time.Second, timeoutOf("scheduler"))
+	// not in the file, the flags apply
+	assert.Equal(t, *collectorTimeout["queue"], timeoutOf("queue"))
+	assert.Equal(t, *collectorInterval["queue"], intervalOf("queue"))
+
+	jc, err := NewJobCollector(log.NewNopLogger(), fixtureClient{})
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, jc.(*JobCollector).lookback)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	assert.ErrorContains(t, loadTestConfig(t, "collectors:\n  unknown:\n    enabled: true\n"), `unknown collector "unknown"`)
+	assert.ErrorContains(t, loadTestConfig(t, "collectors:\n  queue:\n    timeout: soon\n"), "couldn't parse config")
+	assert.ErrorContains(t, loadTestConfig(t, "collectors:\n  queue:\n    enable: true\n"), "couldn't parse config")
+	assert.ErrorContains(t, loadTestConfig(t, "collectors:\n  user:\n    options:\n      lookback: 1h\n"), "user has no options")
+	assert.ErrorContains(t, loadTestConfig(t, "collectors:\n  job:\n    options:\n      lookbak: 1h\n"), "invalid options")
+	assert.ErrorContains(t, loadTestConfig(t, "collectors:\n  job:\n    commands:\n      srun: /bin/srun\n"), `unknown command "srun"`)
+	assert.ErrorContains(t, loadTestConfig(t, "collectors:\n  job:\n    labels:\n      not-valid: x\n"), "invalid label name")
+}
+
+// queueDimensionsOf returns the dimensions of the queue collector in the
+// current configuration.
+func queueDimensionsOf(t *testing.T) []string {
+	options := defaultQueueOptions().(*queueOptions)
+	require.NoError(t, decodeOptions("queue", options))
+	return options.Dimensions
+}
+
+func TestReloadConfig(t *testing.T) {
+	require.NoError(t, loadTestConfig(t, "collectors:\n  queue:\n    options:\n      dimensions: [partition]\n"))
+	qc, err := NewQueueCollector(log.NewNopLogger(), fixtureClient{})
+	require.NoError(t, err)
+	key := collectorKey{name: "queue"}
+	initiatedCollectors[key] = qc
+	jc, err := NewJobCollector(log.NewNopLogger(), fixtureClient{})
+	require.NoError(t, err)
+	jobKey := collectorKey{name: "job"}
+	initiatedCollectors[jobKey] = jc
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	reload := func(content string, apply func() error) error {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return ReloadConfig(path, apply)
+	}
+
+	// an invalid option is rejected before anything is replaced
+	err = reload("collectors:\n  queue:\n    options:\n      dimensions: [foo]\n", func() error {
+		t.Fatal("applied an invalid config")
+		return nil
+	})
+	assert.ErrorContains(t, err, `"foo"`)
+	assert.Same(t, qc, initiatedCollectors[key])
+	assert.Equal(t, []string{"partition"}, queueDimensionsOf(t))
+
+	// so is a config the collectors can't be created with
+	err = reload("collectors:\n  queue:\n    options:\n      dimensions: [account]\n", func() error {
+		assert.Equal(t, []string{"account"}, queueDimensionsOf(t))
+		initiatedCollectors[key] = labelledCollector{collector: qc}
+		return errors.New("couldn't create collector")
+	})
+	assert.ErrorContains(t, err, "couldn't create collector")
+	assert.Same(t, qc, initiatedCollectors[key])
+	assert.Equal(t, []string{"partition"}, queueDimensionsOf(t))
+
+	err = reload("collectors:\n  queue:\n    options:\n      dimensions: [account]\n", func() error { return nil })
+	assert.NoError(t, err)
+	assert.NotContains(t, initiatedCollectors, key)
+	assert.Equal(t, []string{"account"}, queueDimensionsOf(t))
+	// the job collector keeps its cursor, its configuration did not change
+	assert.Same(t, jc, initiatedCollectors[jobKey])
+}
+
+func TestLabelledCollector(t *testing.T) {
+	qc, err := NewQueueCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/queue.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_queue_running Running jobs in the cluster
+# TYPE slurm_queue_running gauge
+slurm_queue_running{site="north"} 27
+`
+	c := &registryCollector{Collector: labelledCollector{collector: qc, labels: map[string]string{"site": "north"}}}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_queue_running"))
+	assert.NoError(t, c.err)
+}
+
+func TestCustomClient(t *testing.T) {
+	base := &cliClient{output: "text", env: []string{"SLURM_CONF=/etc/slurm/slurm.conf"}, run: runOutput}
+	custom := customClient(base, CollectorConfig{
+		Commands: map[string]string{"sshare": "/opt/slurm/bin/sshare"},
+		Env:      map[string]string{"SLURM_TIME_FORMAT": "standard"},
+	}).(*cliClient)
+
+	assert.Equal(t, "/opt/slurm/bin/sshare", custom.executable("sshare"))
+	assert.Equal(t, "squeue", custom.executable("squeue"))
+	assert.Equal(t, []string{"SLURM_CONF=/etc/slurm/slurm.conf", "SLURM_TIME_FORMAT=standard"}, custom.env)
+	assert.Equal(t, []string{"SLURM_CONF=/etc/slurm/slurm.conf"}, base.env)
+}
@@ -18,6 +18,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
+	"context"
 	"strconv"
 	"strings"
 
@@ -44,9 +45,20 @@ func ParseCPUs(input string) CPUs {
 	return cpus
 }
 
-func ParseCPUsMetrics(input []byte) *CPUs {
-	cpu := ParseCPUs(string(input))
-	return &cpu
+// Add accumulates the CPUs of other into cpus.
+func (cpus *CPUs) Add(other CPUs) {
+	cpus.alloc += other.alloc
+	cpus.idle += other.idle
+	cpus.other += other.other
+	cpus.total += other.total
+}
+
+func ParseCPUsMetrics(nodes []Node) *CPUs {
+	var cpus CPUs
+	for _, node := range nodes {
+		cpus.Add(node.cpu)
+	}
+	return &cpus
 }
 
 type CPUsCollector struct {
@@ -54,6 +66,7 @@ type CPUsCollector struct {
 	idle   *prometheus.Desc
 	other  *prometheus.Desc
 	total  *prometheus.Desc
+	client SlurmClient
 	logger log.Logger
 }
 
@@ -61,8 +74,9 @@ func init() {
 	registerCollector("cpus", defaultEnabled, NewCPUsCollector)
 }
 
-func NewCPUsCollector(logger log.Logger) (Collector, error) {
+func NewCPUsCollector(logger log.Logger, client SlurmClient) (Collector, error) {
 	return &CPUsCollector{
+		client: client,
 		logger: logger,
 		alloc:  prometheus.NewDesc("slurm_cpus_alloc", "Allocated CPUs", nil, nil),
 		idle:   prometheus.NewDesc("slurm_cpus_idle", "Idle CPUs", nil, nil),
@@ -71,13 +85,13 @@ func NewCPUsCollector(logger log.Logger) (Collector, error) {
 	}, nil
 }
 
-func (cc *CPUsCollector) Collect(ch chan<- prometheus.Metric) error {
-	out, err := RunCommand("sinfo", "-h", "-a", "-o %C")
+func (cc *CPUsCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	nodes, err := cc.client.Nodes(ctx)
 	if err != nil {
 		return err
 	}
 
-	cm := ParseCPUsMetrics(out)
+	cm := ParseCPUsMetrics(nodes)
 	ch <- prometheus.MustNewConstMetric(cc.alloc, prometheus.GaugeValue, cm.alloc)
 	ch <- prometheus.MustNewConstMetric(cc.idle, prometheus.GaugeValue, cm.idle)
 	ch <- prometheus.MustNewConstMetric(cc.other, prometheus.GaugeValue, cm.other)
@@ -16,7 +16,7 @@ func TestParseCPUs(t *testing.T) {
 func TestCPUsMetrics(t *testing.T) {
 	file, _ := os.Open("fixtures/sinfo/cpus.txt")
 	data, _ := io.ReadAll(file)
-	cpus := ParseCPUsMetrics(data)
+	cpus := ParseCPUsMetrics(ParseNodes(data))
 
 	assert.Equal(t, 5725.0, cpus.alloc, "Miscount of alloc CPUs")
 	assert.Equal(t, 877.0, cpus.idle, "Miscount of idle CPUs")
@@ -0,0 +1,348 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// sacctUsageFormat lists the fields of the ended jobs and their steps read
+// from sacct to compute the efficiency of the jobs.
+const sacctUsageFormat = "JobID,State,Partition,Account,User,End,ElapsedRaw,AllocCPUS,NNodes,TotalCPU,MaxRSS,ReqMem,AveRSS,NTasks"
+
+// JobUsage is what a job which ended requested and used, as reported by
+// sacct for the job and its steps.
+type JobUsage struct {
+	id        string
+	partition string
+	account   string
+	user      string
+	// end is a Unix timestamp
+	end     int64
+	elapsed float64
+	cpus    float64
+	// totalCPU is the CPU time used by all steps, in seconds
+	totalCPU float64
+	// memUsed is the peak resident memory of the whole job, the highest of
+	// its steps summed over their tasks, reqMem the memory requested for
+	// the whole job, in bytes
+	memUsed float64
+	reqMem  float64
+}
+
+// stepMemory returns the resident memory of a job step summed over its
+// tasks. sacct only reports the peak of the largest task, MaxRSS, and the
+// average of the tasks, AveRSS.
+func stepMemory(maxRSS, aveRSS, tasks float64) float64 {
+	return math.Max(maxRSS, aveRSS*tasks)
+}
+
+// CPUEfficiency returns the share of the allocated CPU time the job used,
+// false if it had no CPU time allocated.
+func (j JobUsage) CPUEfficiency() (float64, bool) {
+	allocated := j.elapsed * j.cpus
+	if allocated <= 0 {
+		return 0, false
+	}
+	return j.totalCPU / allocated, true
+}
+
+// MemoryEfficiency returns the share of the requested memory the job used
+// at its peak, false if it requested no memory.
+func (j JobUsage) MemoryEfficiency() (float64, bool) {
+	if j.reqMem <= 0 {
+		return 0, false
+	}
+	return j.memUsed / j.reqMem, true
+}
+
+// requestedMemory returns the memory requested for a whole job from the
+// ReqMem of sacct, which before Slurm 21.08 is given per node (n) or per CPU
+// (c) and since is the total.
+func requestedMemory(reqMem string, cpus, nodes float64) float64 {
+	memory := ParseMemory(reqMem)
+	switch {
+	case strings.HasSuffix(reqMem, "c"):
+		return memory * cpus
+	case strings.HasSuffix(reqMem, "n"):
+		return memory * math.Max(nodes, 1)
+	}
+	return memory
+}
+
+// ParseJobUsage parses the output of sacct -P formatted with
+// sacctUsageFormat, with the lines of the job steps. The steps, e.g.
+// 1234.batch or 1234.0, only add to the memory used by their job.
+func ParseJobUsage(input []byte) []JobUsage {
+	return parseJobUsage(input, time.Local)
+}
+
+// parseJobUsage reads the End times in loc.
+func parseJobUsage(input []byte, loc *time.Location) []JobUsage {
+	var jobs []JobUsage
+	index := make(map[string]int)
+	for _, line := range SplitLines(input) {
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if len(parts) < 14 {
+			continue
+		}
+
+		if id, _, isStep := strings.Cut(parts[0], "."); isStep {
+			if i, ok := index[id]; ok {
+				tasks, _ := strconv.ParseFloat(parts[13], 64)
+				used := stepMemory(ParseMemory(parts[10]), ParseMemory(parts[12]), tasks)
+				jobs[i].memUsed = math.Max(jobs[i].memUsed, used)
+			}
+			continue
+		}
+
+		end, err := parseSlurmTime(parts[5], loc)
+		if err != nil {
+			continue
+		}
+		job := JobUsage{
+			id:        parts[0],
+			partition: parts[2],
+			account:   parts[3],
+			user:      parts[4],
+			end:       end,
+		}
+		job.elapsed, _ = strconv.ParseFloat(parts[6], 64)
+		job.cpus, _ = strconv.ParseFloat(parts[7], 64)
+		nodes, _ := strconv.ParseFloat(parts[8], 64)
+		job.totalCPU, _ = ParseElapsedTime(parts[9])
+		job.reqMem = requestedMemory(parts[11], job.cpus, nodes)
+		index[job.id] = len(jobs)
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// efficiencyOptions are the options of the job_efficiency collector in the
+// configuration file.
+type efficiencyOptions struct {
+	sacctOptions `yaml:",inline"`
+	// TopUsers is the number of users exported with their own efficiency,
+	// those who wasted the most CPU time, 0 exports none
+	TopUsers int `yaml:"top_users"`
+}
+
+func defaultEfficiencyOptions() interface{} {
+	return &efficiencyOptions{sacctOptions: sacctOptions{Lookback: model.Duration(time.Hour)}}
+}
+
+// efficiencyBuckets are the bounds of the efficiency histograms.
+var efficiencyBuckets = prometheus.LinearBuckets(0.1, 0.1, 10)
+
+// efficiencyKey are the labels the efficiencies are observed by.
+type efficiencyKey struct {
+	account, partition string
+}
+
+// userUsage sums what the jobs of a user requested and used.
+type userUsage struct {
+	cpuAllocated, cpuUsed float64
+	memRequested, memUsed float64
+}
+
+// userJob is the usage of a job which ended, kept for the per user
+// efficiencies while it is within the lookback.
+type userJob struct {
+	user  string
+	end   int64
+	usage userUsage
+}
+
+type EfficiencyCollector struct {
+	cpuEfficiency     *prometheus.Desc
+	memoryEfficiency  *prometheus.Desc
+	userCPUEfficiency *prometheus.Desc
+	userMemEfficiency *prometheus.Desc
+	topUsers          int
+	client            SlurmClient
+	logger            log.Logger
+
+	sacctWindow
+	cpu    map[efficiencyKey]*histogram
+	memory map[efficiencyKey]*histogram
+	// recent are the jobs which ended within the lookback, by end time
+	recent []userJob
+	now    func() time.Time
+}
+
+func init() {
+	registerCollector("job_efficiency", defaultDisabled, NewEfficiencyCollector)
+	registerCollectorOptions("job_efficiency", defaultEfficiencyOptions)
+}
+
+func NewEfficiencyCollector(logger log.Logger, client SlurmClient) (Collector, error) {
+	options := defaultEfficiencyOptions().(*efficiencyOptions)
+	if err := decodeOptions("job_efficiency", options); err != nil {
+		return nil, err
+	}
+
+	labels := []string{"account", "partition"}
+	return &EfficiencyCollector{
+		client:            client,
+		logger:            logger,
+		topUsers:          options.TopUsers,
+		sacctWindow:       sacctWindow{lookback: time.Duration(options.Lookback), cursor: newJobCursor()},
+		cpu:               make(map[efficiencyKey]*histogram),
+		memory:            make(map[efficiencyKey]*histogram),
+		now:               time.Now,
+		cpuEfficiency:     prometheus.NewDesc("slurm_job_cpu_efficiency", "CPU time used by the ended jobs divided by their allocated CPU time", labels, nil),
+		memoryEfficiency:  prometheus.NewDesc("slurm_job_memory_efficiency", "Peak memory of the ended jobs divided by their requested memory", labels, nil),
+		userCPUEfficiency: prometheus.NewDesc("slurm_user_cpu_efficiency", "CPU time used by the ended jobs of the user divided by their allocated CPU time, for the users wasting the most", []string{"user"}, nil),
+		userMemEfficiency: prometheus.NewDesc("slurm_user_memory_efficiency", "Peak memory of the ended jobs of the user divided by their requested memory, for the users wasting the most CPU time", []string{"user"}, nil),
+	}, nil
+}
+
+func (ec *EfficiencyCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	ec.mtx.Lock()
+	defer ec.mtx.Unlock()
+
+	if err := ec.update(ctx, ec.now()); err != nil {
+		return err
+	}
+
+	for key, h := range ec.cpu {
+		ch <- h.metric(ec.cpuEfficiency, efficiencyBuckets, key.account, key.partition)
+	}
+	for key, h := range ec.memory {
+		ch <- h.metric(ec.memoryEfficiency, efficiencyBuckets, key.account, key.partition)
+	}
+	users := ec.userUsage()
+	for _, user := range wastefulUsers(users, ec.topUsers) {
+		usage := users[user]
+		if usage.cpuAllocated > 0 {
+			ch <- prometheus.MustNewConstMetric(ec.userCPUEfficiency, prometheus.GaugeValue, usage.cpuUsed/usage.cpuAllocated, user)
+		}
+		if usage.memRequested > 0 {
+			ch <- prometheus.MustNewConstMetric(ec.userMemEfficiency, prometheus.GaugeValue, usage.memUsed/usage.memRequested, user)
+		}
+	}
+	return nil
+}
+
+// userUsage sums the usage of the recent jobs per user.
+func (ec *EfficiencyCollector) userUsage() map[string]*userUsage {
+	users := make(map[string]*userUsage)
+	for _, job := range ec.recent {
+		usage, ok := users[job.user]
+		if !ok {
+			usage = &userUsage{}
+			users[job.user] = usage
+		}
+		usage.cpuAllocated += job.usage.cpuAllocated
+		usage.cpuUsed += job.usage.cpuUsed
+		usage.memRequested += job.usage.memRequested
+		usage.memUsed += job.usage.memUsed
+	}
+	return users
+}
+
+// wastefulUsers returns the top users who left the most allocated CPU time
+// unused.
+func wastefulUsers(usage map[string]*userUsage, top int) []string {
+	if top <= 0 {
+		return nil
+	}
+	users := make([]string, 0, len(usage))
+	for user := range usage {
+		users = append(users, user)
+	}
+	wasted := func(user string) float64 {
+		return usage[user].cpuAllocated - usage[user].cpuUsed
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if wasted(users[i]) != wasted(users[j]) {
+			return wasted(users[i]) > wasted(users[j])
+		}
+		return users[i] < users[j]
+	})
+	if len(users) > top {
+		users = users[:top]
+	}
+	return users
+}
+
+// update observes the efficiency of the jobs which ended since the cursor
+// and moves it. The jobs which ended before the lookback are dropped from
+// the per user efficiencies.
+func (ec *EfficiencyCollector) update(ctx context.Context, now time.Time) error {
+	err := ec.advance(now, func(start, end time.Time, isNew func(string, int64) bool) error {
+		jobs, err := ec.client.JobUsage(ctx, start, end)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if isNew(job.id, job.end) {
+				ec.observeJob(job)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	oldest := now.Add(-ec.lookback).Unix()
+	recent := ec.recent[:0]
+	for _, job := range ec.recent {
+		if job.end >= oldest {
+			recent = append(recent, job)
+		}
+	}
+	ec.recent = recent
+	return nil
+}
+
+// observeJob adds the efficiency of an ended job to the histograms and to the
+// usage of its user.
+func (ec *EfficiencyCollector) observeJob(job JobUsage) {
+	key := efficiencyKey{account: job.account, partition: job.partition}
+	var usage userUsage
+	if efficiency, ok := job.CPUEfficiency(); ok {
+		observe(ec.cpu, key, efficiency)
+		usage.cpuAllocated = job.elapsed * job.cpus
+		usage.cpuUsed = job.totalCPU
+	}
+	if efficiency, ok := job.MemoryEfficiency(); ok {
+		observe(ec.memory, key, efficiency)
+		usage.memRequested = job.reqMem
+		usage.memUsed = job.memUsed
+	}
+	ec.recent = append(ec.recent, userJob{user: job.user, end: job.end, usage: usage})
+}
+
+func observe(histograms map[efficiencyKey]*histogram, key efficiencyKey, value float64) {
+	h, ok := histograms[key]
+	if !ok {
+		h = &histogram{}
+		histograms[key] = h
+	}
+	h.observe(efficiencyBuckets, value)
+}
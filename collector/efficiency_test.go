@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJobUsage(t *testing.T) {
+	// the fixture was recorded in UTC
+	jobs := parseJobUsage(readFixture(t, "fixtures/sacct/usage.txt"), time.UTC)
+
+	require.Len(t, jobs, 4)
+	assert.Equal(t, JobUsage{
+		id:        "96001",
+		partition: "gpu",
+		account:   "account1",
+		user:      "user1",
+		end:       time.Date(2024, 6, 19, 9, 0, 0, 0, time.UTC).Unix(),
+		elapsed:   3600,
+		cpus:      64,
+		totalCPU:  3600,
+		// 16 tasks of 3G on average
+		memUsed: 48 * 1024 * 1024 * 1024,
+		reqMem:  500 * 1024 * 1024 * 1024,
+	}, jobs[0])
+	// requested per CPU and per node
+	assert.Equal(t, 16000.0*1024*1024, jobs[1].reqMem)
+	assert.Equal(t, 16.0*1024*1024*1024, jobs[2].reqMem)
+	assert.Equal(t, 3500.5, jobs[1].totalCPU)
+
+	efficiency, ok := jobs[1].CPUEfficiency()
+	assert.True(t, ok)
+	assert.Equal(t, 0.875125, efficiency)
+	efficiency, ok = jobs[2].MemoryEfficiency()
+	assert.True(t, ok)
+	// 2 tasks of 7G on average, against 8G on each of the 2 nodes
+	assert.Equal(t, 0.875, efficiency)
+	_, ok = jobs[3].CPUEfficiency()
+	assert.False(t, ok)
+
+	fromJSON, err := ParseJobUsageJSON(readFixture(t, "fixtures/sacct/slurm-23.11.4/usage.json"))
+	require.NoError(t, err)
+	assert.Equal(t, jobs, fromJSON)
+}
+
+func TestEfficiencyCollector(t *testing.T) {
+	require.NoError(t, loadTestConfig(t, "collectors:\n  job_efficiency:\n    options:\n      top_users: 2\n"))
+	c, err := NewEfficiencyCollector(log.NewNopLogger(), fixtureClient{jobUsage: "fixtures/sacct/usage.txt"})
+	require.NoError(t, err)
+	ec := c.(*EfficiencyCollector)
+	ec.now = func() time.Time { return time.Date(2024, 6, 19, 10, 0, 0, 0, time.Local) }
+
+	expected := `
+# HELP slurm_user_cpu_efficiency CPU time used by the ended jobs of the user divided by their allocated CPU time, for the users wasting the most
+# TYPE slurm_user_cpu_efficiency gauge
+slurm_user_cpu_efficiency{user="user1"} 0.015625
+slurm_user_cpu_efficiency{user="user2"} 0.875125
+`
+	rc := &registryCollector{Collector: ec}
+	assert.NoError(t, testutil.CollectAndCompare(rc, strings.NewReader(expected), "slurm_user_cpu_efficiency"))
+	assert.NoError(t, rc.err)
+	assert.Equal(t, 2, testutil.CollectAndCount(rc, "slurm_job_cpu_efficiency"))
+	assert.Equal(t, 3, testutil.CollectAndCount(rc, "slurm_job_memory_efficiency"))
+
+	h := ec.cpu[efficiencyKey{account: "account2", partition: "cpu"}]
+	require.NotNil(t, h)
+	assert.Equal(t, uint64(2), h.count)
+	// 0.875125 and 0.99993...
+	assert.Equal(t, []uint64{0, 0, 0, 0, 0, 0, 0, 0, 1, 1}, h.counts)
+
+	// 96001 and 96002 ended more than the lookback ago, 96004 used no CPU
+	ec.now = func() time.Time { return time.Date(2024, 6, 19, 10, 15, 0, 0, time.Local) }
+	expected = `
+# HELP slurm_user_cpu_efficiency CPU time used by the ended jobs of the user divided by their allocated CPU time, for the users wasting the most
+# TYPE slurm_user_cpu_efficiency gauge
+slurm_user_cpu_efficiency{user="user3"} 0.9999305555555555
+`
+	assert.NoError(t, testutil.CollectAndCompare(rc, strings.NewReader(expected), "slurm_user_cpu_efficiency"))
+	assert.Equal(t, 2, testutil.CollectAndCount(rc, "slurm_job_cpu_efficiency"))
+}
@@ -0,0 +1,132 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ExpectedStartMetrics describes when the pending jobs of a partition are
+// expected to start, as estimated by the backfill scheduler.
+type ExpectedStartMetrics struct {
+	// wait observes the time until the expected start of the jobs with an
+	// estimate, withoutEstimate counts the others
+	wait            histogram
+	withoutEstimate float64
+	// soonest is a Unix timestamp, 0 if no job has an estimate
+	soonest int64
+}
+
+// ParseExpectedStarts groups the pending jobs by partition. The pending
+// tasks of an array count as many jobs, like in the queue metrics. The
+// expected wait of jobs whose estimate has already passed is 0.
+func ParseExpectedStarts(jobs []Job, buckets []float64, now time.Time) map[string]*ExpectedStartMetrics {
+	partitions := make(map[string]*ExpectedStartMetrics)
+	for _, job := range jobs {
+		// the components of a heterogeneous job start together
+		if job.state != "PENDING" || job.count() == 0 {
+			continue
+		}
+		pm, ok := partitions[job.partition]
+		if !ok {
+			pm = &ExpectedStartMetrics{}
+			partitions[job.partition] = pm
+		}
+		if job.expectedStart == 0 {
+			pm.withoutEstimate += job.count()
+			continue
+		}
+		wait := float64(job.expectedStart - now.Unix())
+		if wait < 0 {
+			wait = 0
+		}
+		pm.wait.observeCount(buckets, wait, uint64(job.count()))
+		if pm.soonest == 0 || job.expectedStart < pm.soonest {
+			pm.soonest = job.expectedStart
+		}
+	}
+	return partitions
+}
+
+// expectedStartOptions are the options of the expected_start collector in
+// the configuration file.
+type expectedStartOptions struct {
+	// Buckets are the upper bounds of the expected wait histogram, in
+	// seconds
+	Buckets []float64 `yaml:"buckets"`
+}
+
+func (o *expectedStartOptions) validate() error {
+	return checkBuckets("expected_start", o.Buckets)
+}
+
+func defaultExpectedStartOptions() interface{} {
+	return &expectedStartOptions{
+		Buckets: []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400, 172800, 604800},
+	}
+}
+
+type ExpectedStartCollector struct {
+	expectedWait    *prometheus.Desc
+	withoutEstimate *prometheus.Desc
+	soonest         *prometheus.Desc
+	buckets         []float64
+	client          SlurmClient
+	logger          log.Logger
+}
+
+func init() {
+	registerCollector("expected_start", defaultDisabled, NewExpectedStartCollector)
+	registerCollectorOptions("expected_start", defaultExpectedStartOptions)
+}
+
+func NewExpectedStartCollector(logger log.Logger, client SlurmClient) (Collector, error) {
+	options := defaultExpectedStartOptions().(*expectedStartOptions)
+	if err := decodeOptions("expected_start", options); err != nil {
+		return nil, err
+	}
+
+	labels := []string{"partition"}
+	return &ExpectedStartCollector{
+		client:          client,
+		logger:          logger,
+		buckets:         options.Buckets,
+		expectedWait:    prometheus.NewDesc("slurm_partition_expected_wait_seconds", "Time until the pending jobs are expected to start, as estimated by the scheduler", labels, nil),
+		withoutEstimate: prometheus.NewDesc("slurm_partition_pending_without_estimate", "Pending jobs the scheduler has no expected start for", labels, nil),
+		soonest:         prometheus.NewDesc("slurm_partition_soonest_expected_start_timestamp_seconds", "Soonest expected start of the pending jobs, as a Unix timestamp", labels, nil),
+	}, nil
+}
+
+func (ec *ExpectedStartCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	jobs, err := ec.client.Jobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for partition, pm := range ParseExpectedStarts(jobs, ec.buckets, time.Now()) {
+		ch <- pm.wait.metric(ec.expectedWait, ec.buckets, partition)
+		ch <- prometheus.MustNewConstMetric(ec.withoutEstimate, prometheus.GaugeValue, pm.withoutEstimate, partition)
+		if pm.soonest > 0 {
+			ch <- prometheus.MustNewConstMetric(ec.soonest, prometheus.GaugeValue, float64(pm.soonest), partition)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpectedStarts(t *testing.T) {
+	// the fixture was recorded in UTC
+	jobs := parseJobs(readFixture(t, "fixtures/squeue/jobs_active.txt"), time.UTC)
+	buckets := []float64{60, 3600, 86400}
+	partitions := ParseExpectedStarts(jobs, buckets, time.Unix(1700000000, 0))
+
+	require.Len(t, partitions, 2)
+	assert.Equal(t, int64(1700003600), partitions["cpu"].soonest)
+	assert.Equal(t, 0.0, partitions["cpu"].withoutEstimate)
+	assert.Equal(t, histogram{count: 1, sum: 3600, counts: []uint64{0, 1, 0}}, partitions["cpu"].wait)
+	assert.Equal(t, int64(0), partitions["gpu"].soonest)
+	assert.Equal(t, 1.0, partitions["gpu"].withoutEstimate)
+	assert.Equal(t, uint64(0), partitions["gpu"].wait.count)
+
+	// an estimate in the past is a wait of 0
+	partitions = ParseExpectedStarts(jobs, buckets, time.Unix(1700007200, 0))
+	assert.Equal(t, 0.0, partitions["cpu"].wait.sum)
+
+	// the pending tasks of an array count in both
+	array := []Job{
+		{partition: "cpu", state: "PENDING", arrayJobID: "94510", arrayTasks: 4, expectedStart: 1700000060},
+		{partition: "cpu", state: "PENDING", arrayJobID: "94511", arrayTasks: 3},
+	}
+	partitions = ParseExpectedStarts(array, buckets, time.Unix(1700000000, 0))
+	assert.Equal(t, histogram{count: 4, sum: 240, counts: []uint64{4, 0, 0}}, partitions["cpu"].wait)
+	assert.Equal(t, 3.0, partitions["cpu"].withoutEstimate)
+}
+
+func TestExpectedStartCollector(t *testing.T) {
+	ec, err := NewExpectedStartCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/jobs_active.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_partition_pending_without_estimate Pending jobs the scheduler has no expected start for
+# TYPE slurm_partition_pending_without_estimate gauge
+slurm_partition_pending_without_estimate{partition="cpu"} 0
+slurm_partition_pending_without_estimate{partition="gpu"} 1
+`
+	c := &registryCollector{Collector: ec}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_partition_pending_without_estimate"))
+	assert.NoError(t, c.err)
+	// a histogram per partition and the soonest start of cpu
+	assert.Equal(t, 5, testutil.CollectAndCount(c))
+}
+
+func TestExpectedStartCollectorBuckets(t *testing.T) {
+	err := loadTestConfig(t, "collectors:\n  expected_start:\n    options:\n      buckets: [60, 30]\n")
+	assert.ErrorContains(t, err, "increasing order")
+}
@@ -19,6 +19,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
+	"context"
 	"strconv"
 	"strings"
 
@@ -67,34 +68,19 @@ func ParseGenericResources(input string) []GenericResource {
 	return results
 }
 
-func ParseGPUsMetrics(data []byte) *GPUsMetrics {
+func ParseGPUsMetrics(nodes []Node) *GPUsMetrics {
 	var usedGpus = 0.0
 	var totalGpus = 0.0
-	for _, line := range SplitLines(data) {
-		if len(line) > 0 && strings.Contains(line, "gpu:") {
-			parts := strings.Fields(line)
-			numNodes, _ := strconv.ParseFloat(parts[0], 64)
-			if numNodes == 0 { // for old slurm the results were not grouped and `NodeName` was shown in first column
-				numNodes = 1
+	for _, node := range nodes {
+		for _, gres := range node.gres {
+			if gres.gresType == "gpu" {
+				totalGpus += gres.count
 			}
-			gres := ParseGenericResources(parts[1])
-			gresUsed := ParseGenericResources(parts[2])
-
-			numNodeGpus := 0.0
-			for _, gres := range gres {
-				if gres.gresType == "gpu" {
-					numNodeGpus += gres.count
-				}
-			}
-			totalGpus += numNodes * numNodeGpus
-
-			numNodeUsedGpus := 0.0
-			for _, gres := range gresUsed {
-				if gres.gresType == "gpu" {
-					numNodeUsedGpus += gres.count
-				}
+		}
+		for _, gres := range node.gresUsed {
+			if gres.gresType == "gpu" {
+				usedGpus += gres.count
 			}
-			usedGpus += numNodes * numNodeUsedGpus
 		}
 	}
 
@@ -111,6 +97,7 @@ type GPUsCollector struct {
 	idle        *prometheus.Desc
 	total       *prometheus.Desc
 	utilization *prometheus.Desc
+	client      SlurmClient
 	logger      log.Logger
 }
 
@@ -118,8 +105,9 @@ func init() {
 	registerCollector("gpus", defaultEnabled, NewGPUsCollector)
 }
 
-func NewGPUsCollector(logger log.Logger) (Collector, error) {
+func NewGPUsCollector(logger log.Logger, client SlurmClient) (Collector, error) {
 	return &GPUsCollector{
+		client:      client,
 		logger:      logger,
 		alloc:       prometheus.NewDesc("slurm_gpus_alloc", "Allocated GPUs", nil, nil),
 		idle:        prometheus.NewDesc("slurm_gpus_idle", "Idle GPUs", nil, nil),
@@ -128,13 +116,13 @@ func NewGPUsCollector(logger log.Logger) (Collector, error) {
 	}, nil
 }
 
-func (cc *GPUsCollector) Collect(ch chan<- prometheus.Metric) error {
-	out, err := RunCommand("sinfo", "-a", "-h", "--Format=Nodes: ,Gres: ,GresUsed:")
+func (cc *GPUsCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	nodes, err := cc.client.Nodes(ctx)
 	if err != nil {
 		return err
 	}
 
-	cm := ParseGPUsMetrics(out)
+	cm := ParseGPUsMetrics(nodes)
 	ch <- prometheus.MustNewConstMetric(cc.alloc, prometheus.GaugeValue, cm.alloc)
 	ch <- prometheus.MustNewConstMetric(cc.idle, prometheus.GaugeValue, cm.idle)
 	ch <- prometheus.MustNewConstMetric(cc.total, prometheus.GaugeValue, cm.total)
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -21,6 +22,35 @@ func TestParseGenericResources(t *testing.T) {
 }
 
 func TestGPUsMetrics(t *testing.T) {
+	metrics := ParseGPUsMetrics(ParseNodes(readFixture(t, "fixtures/sinfo/gpus.txt")))
+	assert.Equal(t, 41.0, metrics.idle)
+	assert.Equal(t, 7.0, metrics.alloc)
+	assert.Equal(t, 48.0, metrics.total)
+}
+
+// recordedGresNodes reads the output of sinfo --Format=Nodes: ,Gres: ,GresUsed:
+// recorded from older Slurm versions into a node per line, repeated for the
+// number of nodes the line groups. Slurm before 20.11 printed the node name
+// instead of grouping them.
+func recordedGresNodes(data []byte) []Node {
+	var nodes []Node
+	for _, line := range SplitLines(data) {
+		parts := strings.Fields(line)
+		if len(parts) < 3 {
+			continue
+		}
+		count, err := strconv.Atoi(parts[0])
+		if err != nil {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			nodes = append(nodes, Node{gres: ParseGenericResources(parts[1]), gresUsed: ParseGenericResources(parts[2])})
+		}
+	}
+	return nodes
+}
+
+func TestGPUsMetricsRecorded(t *testing.T) {
 	testDataPaths, _ := filepath.Glob("fixtures/sinfo/slurm-*")
 	for _, testDataPath := range testDataPaths {
 		slurmVersion := strings.TrimPrefix(testDataPath, "fixtures/sinfo/slurm-")
@@ -29,7 +59,7 @@ func TestGPUsMetrics(t *testing.T) {
 		file, _ := os.Open(testDataPath + "/gpus.txt")
 		data, _ := io.ReadAll(file)
 
-		metrics := ParseGPUsMetrics(data)
+		metrics := ParseGPUsMetrics(recordedGresNodes(data))
 		assert.Equal(t, 41.0, metrics.idle)
 		assert.Equal(t, 7.0, metrics.alloc)
 		assert.Equal(t, 48.0, metrics.total)
@@ -1,5 +1,4 @@
 /*
-	Copyright 2023 Atrestis Karalis
 	Copyright 2024 Oleh Astappiev
 
 This program is free software: you can redistribute it and/or modify
@@ -18,121 +17,453 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-kit/kit/log/level"
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 )
 
-type JobIdMetrics struct {
-	JobID   string
-	JobName string
-	User    string
-	Elapsed float64
+// sacctFormat lists the fields of the ended jobs read from sacct.
+const sacctFormat = "JobID,State,Partition,Account,User,End,ElapsedRaw,AllocCPUS,AllocTRES,ExitCode,DerivedExitCode"
+
+// endedJobStates are the states a job ends in. Asked for them, sacct lists
+// the jobs which ended within the requested window.
+const endedJobStates = "BOOT_FAIL,CANCELLED,COMPLETED,DEADLINE,FAILED,NODE_FAIL,OUT_OF_MEMORY,PREEMPTED,TIMEOUT"
+
+// EndedJob is a job which reached one of the endedJobStates, as reported by
+// sacct.
+type EndedJob struct {
+	id        string
+	state     string
+	partition string
+	account   string
+	user      string
+	// end is a Unix timestamp
+	end     int64
+	elapsed float64
+	cpus    float64
+	gpus    float64
+	// exitCode is the one of the batch script, derivedExitCode the highest
+	// one of the job steps
+	exitCode        exitCode
+	derivedExitCode exitCode
 }
 
-func ParseJobLine(line string) (*JobIdMetrics, error) {
-	fields := strings.Fields(line)
-	if len(fields) >= 4 {
-		elapsed, err := ParseElapsedTime(fields[3])
-		if err != nil {
-			return nil, fmt.Errorf("error parsing job: %w", err)
-		}
+// exitCode is an exit code as printed by sacct, code:signal.
+type exitCode struct {
+	code, signal int
+}
+
+func parseExitCode(input string) exitCode {
+	code, signal, _ := strings.Cut(input, ":")
+	var ec exitCode
+	ec.code, _ = strconv.Atoi(code)
+	ec.signal, _ = strconv.Atoi(signal)
+	return ec
+}
 
-		return &JobIdMetrics{
-			JobID:   fields[0],
-			JobName: fields[1],
-			User:    fields[2],
-			Elapsed: elapsed,
-		}, nil
+// ExitClass sorts out why a job ended: success, error for a non-zero exit
+// code, signal if it was killed, or oom. A zero exit code of the batch
+// script falls back to the one of the steps, and shells exit with 128+n
+// when their command is killed by signal n.
+func (j EndedJob) ExitClass() (class string, signal int) {
+	ec := j.exitCode
+	if ec == (exitCode{}) {
+		ec = j.derivedExitCode
+	}
+	if ec.signal == 0 && ec.code > 128 && ec.code <= 128+64 {
+		ec = exitCode{signal: ec.code - 128}
+	}
+	switch {
+	case j.state == "OUT_OF_MEMORY":
+		return "oom", ec.signal
+	case ec.signal != 0:
+		return "signal", ec.signal
+	case ec.code != 0:
+		return "error", 0
+	default:
+		return "success", 0
 	}
-	return nil, fmt.Errorf("no job lines found")
 }
 
-func ParseJobMetrics(logger log.Logger, input []byte) []*JobIdMetrics {
-	var metrics []*JobIdMetrics
+// ParseEndedJobs parses the output of sacct -P formatted with sacctFormat.
+// Lines without a valid end time are skipped.
+func ParseEndedJobs(input []byte) []EndedJob {
+	return parseEndedJobs(input, time.Local)
+}
 
+// parseEndedJobs reads the End times in loc, see parseSlurmTime.
+func parseEndedJobs(input []byte, loc *time.Location) []EndedJob {
+	var jobs []EndedJob
 	for _, line := range SplitLines(input) {
-		jobMetrics, err := ParseJobLine(line)
-		if jobMetrics != nil {
-			metrics = append(metrics, jobMetrics)
-		} else if err == nil {
-			level.Warn(logger).Log("msg", "Unable to parser job line", "err", err)
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if len(parts) < 9 {
+			continue
+		}
+		end, err := parseSlurmTime(parts[5], loc)
+		if err != nil {
+			continue
 		}
+		job := EndedJob{
+			id: parts[0],
+			// e.g. "CANCELLED by 1234"
+			state:     strings.Fields(parts[1] + " ")[0],
+			partition: parts[2],
+			account:   parts[3],
+			user:      parts[4],
+			end:       end,
+			gpus:      tresCount(parts[8], "gres/gpu"),
+		}
+		job.elapsed, _ = strconv.ParseFloat(parts[6], 64)
+		job.cpus, _ = strconv.ParseFloat(parts[7], 64)
+		if len(parts) >= 11 {
+			job.exitCode = parseExitCode(parts[9])
+			job.derivedExitCode = parseExitCode(parts[10])
+		}
+		jobs = append(jobs, job)
 	}
+	return jobs
+}
 
-	return metrics
+// parseSlurmTime parses a timestamp printed by the Slurm commands, e.g.
+// 2024-06-19T10:00:00, into a Unix timestamp. Slurm prints Unknown or None
+// for times which are not set. The commands print the times without their
+// time zone, in the local one of the exporter; the exported Parse functions
+// read them in time.Local and the tests read the fixtures in UTC.
+func parseSlurmTime(value string, loc *time.Location) (int64, error) {
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", value, loc)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
 }
 
+// tresCount returns the count of a resource in a TRES list, e.g. 2 for
+// gres/gpu in "billing=8,cpu=8,gres/gpu=2,mem=64G,node=1".
+func tresCount(tres string, name string) float64 {
+	for _, single := range strings.Split(tres, ",") {
+		if key, value, ok := strings.Cut(single, "="); ok && key == name {
+			count, _ := strconv.ParseFloat(value, 64)
+			return count
+		}
+	}
+	return 0
+}
+
+// ParseElapsedTime parses a duration as printed by Slurm, [D-]HH:MM:SS or
+// MM:SS, into seconds. The seconds may have a fraction, as in the TotalCPU
+// of sacct.
 func ParseElapsedTime(elapsedStr string) (float64, error) {
-	parts := strings.Split(elapsedStr, ":")
-	if len(parts) != 3 {
+	days := 0
+	clock := elapsedStr
+	if d, rest, ok := strings.Cut(elapsedStr, "-"); ok {
+		var err error
+		if days, err = strconv.Atoi(d); err != nil {
+			return 0, fmt.Errorf("invalid elapsed time format: %s", elapsedStr)
+		}
+		clock = rest
+	}
+
+	parts := strings.Split(clock, ":")
+	if len(parts) < 2 || len(parts) > 3 {
 		return 0, fmt.Errorf("invalid elapsed time format: %s", elapsedStr)
 	}
 
-	hours, err := strconv.Atoi(parts[0])
+	seconds := 0
+	for _, part := range parts[:len(parts)-1] {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, err
+		}
+		seconds = seconds*60 + value
+	}
+	fraction, err := strconv.ParseFloat(parts[len(parts)-1], 64)
 	if err != nil {
 		return 0, err
 	}
 
-	minutes, err := strconv.Atoi(parts[1])
+	elapsedSeconds := float64(days*24*3600+seconds*60) + fraction
+	return elapsedSeconds, nil
+}
+
+// cursorOverlap is how far before the cursor sacct is asked for jobs again,
+// for the jobs slurmdbd learns about late. The jobs seen within it are
+// remembered so they are not counted twice.
+const cursorOverlap = 10 * time.Minute
+
+// jobCursor is the position of a collector in the accounting data, e.g. the
+// latest end time of the jobs it counted. It is what the state file holds.
+type jobCursor struct {
+	// Latest is the latest time seen, as a Unix timestamp
+	Latest int64 `json:"latest"`
+	// Seen maps the jobs within cursorOverlap of Latest to their time
+	Seen map[string]int64 `json:"seen"`
+}
+
+func newJobCursor() jobCursor {
+	return jobCursor{Seen: make(map[string]int64)}
+}
+
+// since returns the start of the window sacct is asked for, which is never
+// more than lookback before now.
+func (c *jobCursor) since(now time.Time, lookback time.Duration) time.Time {
+	start := now.Add(-lookback)
+	if c.Latest > 0 {
+		if overlap := time.Unix(c.Latest, 0).Add(-cursorOverlap); overlap.After(start) {
+			start = overlap
+		}
+	}
+	return start
+}
+
+// add moves the cursor to a job at the given time, it reports false if the
+// job was seen already.
+func (c *jobCursor) add(id string, at int64) bool {
+	if _, ok := c.Seen[id]; ok {
+		return false
+	}
+	c.Seen[id] = at
+	if at > c.Latest {
+		c.Latest = at
+	}
+	return true
+}
+
+// prune forgets the jobs which fell out of the overlap.
+func (c *jobCursor) prune() {
+	oldest := time.Unix(c.Latest, 0).Add(-cursorOverlap).Unix()
+	for id, at := range c.Seen {
+		if at < oldest {
+			delete(c.Seen, id)
+		}
+	}
+}
+
+// sacctOptions are the options of the collectors built on a sacctWindow.
+type sacctOptions struct {
+	// Lookback bounds how far back sacct is asked for jobs, e.g. after a
+	// long downtime
+	Lookback model.Duration `yaml:"lookback"`
+}
+
+// sacctWindow moves a collector through the accounting data: every run asks
+// sacct for the jobs since the previous one, and each job is counted once.
+type sacctWindow struct {
+	// mtx serializes the runs, each one moves the cursor
+	mtx      sync.Mutex
+	lookback time.Duration
+	cursor   jobCursor
+}
+
+// advance calls fetch with the window from the cursor to now. fetch passes
+// each job sacct lists to isNew, which moves the cursor to the job's time
+// and reports whether it was not counted before.
+func (w *sacctWindow) advance(now time.Time, fetch func(start, end time.Time, isNew func(id string, at int64) bool) error) error {
+	start := w.cursor.since(now, w.lookback)
+	isNew := func(id string, at int64) bool {
+		return at >= start.Unix() && w.cursor.add(id, at)
+	}
+	if err := fetch(start, now, isNew); err != nil {
+		return err
+	}
+	w.cursor.prune()
+	return nil
+}
+
+// readJobCursor reads the cursor from a state file, a missing file is an
+// empty cursor.
+func readJobCursor(path string) (jobCursor, error) {
+	cursor := newJobCursor()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cursor, nil
+	}
 	if err != nil {
-		return 0, err
+		return cursor, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	if cursor.Seen == nil {
+		cursor.Seen = make(map[string]int64)
 	}
+	return cursor, nil
+}
 
-	seconds, err := strconv.Atoi(parts[2])
+// writeJobCursor replaces the state file, through a rename so that it is
+// never left half written.
+func writeJobCursor(path string, cursor jobCursor) error {
+	data, err := json.Marshal(cursor)
 	if err != nil {
-		return 0, err
+		return err
 	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
 
-	elapsedSeconds := float64(hours*3600 + minutes*60 + seconds)
-	return elapsedSeconds, nil
+// jobOptions are the options of the job collector in the configuration file.
+type jobOptions struct {
+	sacctOptions `yaml:",inline"`
+	// StateFile keeps the cursor across restarts, the name of the cluster
+	// is appended to it for the clusters given with --slurm.cluster
+	StateFile string `yaml:"state_file"`
+}
+
+func defaultJobOptions() interface{} {
+	return &jobOptions{sacctOptions: sacctOptions{Lookback: model.Duration(30 * time.Hour)}}
+}
+
+// endedJobsKey are the labels the ended jobs are counted by.
+type endedJobsKey struct {
+	state, partition, account string
+}
+
+type endedJobsCounters struct {
+	jobs, cpuSeconds, gpuSeconds float64
+}
+
+// exitKey are the labels the ended jobs are counted by per exit reason.
+type exitKey struct {
+	class, signal, partition, account string
 }
 
 type JobCollector struct {
-	jobInfo *prometheus.Desc
-	logger  log.Logger
+	ended      *prometheus.Desc
+	cpuSeconds *prometheus.Desc
+	gpuSeconds *prometheus.Desc
+	exits      *prometheus.Desc
+	stateFile  string
+	client     SlurmClient
+	logger     log.Logger
+
+	sacctWindow
+	counters   map[endedJobsKey]*endedJobsCounters
+	exitCounts map[exitKey]float64
 }
 
 func init() {
 	registerCollector("job", defaultDisabled, NewJobCollector)
+	registerCollectorOptions("job", defaultJobOptions)
 }
 
-func NewJobCollector(logger log.Logger) (Collector, error) {
+func NewJobCollector(logger log.Logger, client SlurmClient) (Collector, error) {
+	options := defaultJobOptions().(*jobOptions)
+	if err := decodeOptions("job", options); err != nil {
+		return nil, err
+	}
+	stateFile := options.StateFile
+	if cluster := clusterOf(client); stateFile != "" && cluster != "" {
+		stateFile += "." + cluster
+	}
+	cursor := newJobCursor()
+	if stateFile != "" {
+		var err error
+		if cursor, err = readJobCursor(stateFile); err != nil {
+			return nil, err
+		}
+	}
+
+	labels := []string{"state", "partition", "account"}
 	return &JobCollector{
-		logger:  logger,
-		jobInfo: prometheus.NewDesc("slurm_job_info", "Slurm Job Information", []string{"JobID", "JobName", "User"}, nil),
+		client:      client,
+		logger:      logger,
+		stateFile:   stateFile,
+		sacctWindow: sacctWindow{lookback: time.Duration(options.Lookback), cursor: cursor},
+		counters:    make(map[endedJobsKey]*endedJobsCounters),
+		exitCounts:  make(map[exitKey]float64),
+		ended:       prometheus.NewDesc("slurm_jobs_ended_total", "Jobs which ended, by final state", labels, nil),
+		cpuSeconds:  prometheus.NewDesc("slurm_jobs_ended_cpu_seconds_total", "CPU time allocated to the jobs which ended", labels, nil),
+		gpuSeconds:  prometheus.NewDesc("slurm_jobs_ended_gpu_seconds_total", "GPU time allocated to the jobs which ended", labels, nil),
+		exits:       prometheus.NewDesc("slurm_jobs_ended_exit_total", "Jobs which ended, by exit class and terminating signal", []string{"class", "signal", "partition", "account"}, nil),
 	}, nil
 }
 
-func (jc *JobCollector) Collect(ch chan<- prometheus.Metric) error {
-	// Calculate the time one hour ago
-	oneHourAgoTime := time.Now().Add(-30 * time.Hour)
-	currentTime := time.Now()
+func (jc *JobCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	jc.mtx.Lock()
+	defer jc.mtx.Unlock()
+
+	if err := jc.update(ctx, time.Now()); err != nil {
+		return err
+	}
+	for key, c := range jc.counters {
+		ch <- prometheus.MustNewConstMetric(jc.ended, prometheus.CounterValue, c.jobs, key.state, key.partition, key.account)
+		ch <- prometheus.MustNewConstMetric(jc.cpuSeconds, prometheus.CounterValue, c.cpuSeconds, key.state, key.partition, key.account)
+		ch <- prometheus.MustNewConstMetric(jc.gpuSeconds, prometheus.CounterValue, c.gpuSeconds, key.state, key.partition, key.account)
+	}
+	for key, count := range jc.exitCounts {
+		ch <- prometheus.MustNewConstMetric(jc.exits, prometheus.CounterValue, count, key.class, key.signal, key.partition, key.account)
+	}
+	return nil
+}
 
-	out, err := RunCommand("sacct", "--state=COMPLETED",
-		"-S"+oneHourAgoTime.Format("2006-01-02T15:04:05"),
-		"-E"+currentTime.Format("2006-01-02T15:04:05"),
-		"-X", "-n", "-a",
-		"--format=JobID,JobName,User,Elapsed")
+// update counts the jobs which ended since the cursor and moves it.
+// Without a saved cursor, the first run starts the cursor at now and only
+// remembers the jobs within the overlap: the counters start at 0 with the
+// exporter, so counting the jobs which ended before would make them jump.
+func (jc *JobCollector) update(ctx context.Context, now time.Time) error {
+	count := jc.count
+	if jc.cursor.Latest == 0 {
+		jc.cursor.Latest = now.Unix()
+		count = func(EndedJob) {}
+	}
+	err := jc.advance(now, func(start, end time.Time, isNew func(string, int64) bool) error {
+		jobs, err := jc.client.EndedJobs(ctx, start, end)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if isNew(job.id, job.end) {
+				count(job)
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	jobMetrics := ParseJobMetrics(jc.logger, out)
-	for _, metric := range jobMetrics {
-		if metric == nil {
-			level.Warn(jc.logger).Log("msg", "Skipping nil metric")
-			continue
+	if jc.stateFile != "" {
+		if err := writeJobCursor(jc.stateFile, jc.cursor); err != nil {
+			level.Warn(jc.logger).Log("msg", "Unable to write the state file", "file", jc.stateFile, "err", err)
 		}
-		ch <- prometheus.MustNewConstMetric(jc.jobInfo, prometheus.GaugeValue, metric.Elapsed, metric.JobID, metric.JobName, metric.User)
+	}
+	return nil
+}
 
-		level.Info(jc.logger).Log("msg", fmt.Sprintf("Exported metrics for JobID: %s, JobName: %s, User: %s, Elapsed: %f", metric.JobID, metric.JobName, metric.User, metric.Elapsed))
+// count adds an ended job to the counters.
+func (jc *JobCollector) count(job EndedJob) {
+	key := endedJobsKey{state: job.state, partition: job.partition, account: job.account}
+	c, ok := jc.counters[key]
+	if !ok {
+		c = &endedJobsCounters{}
+		jc.counters[key] = c
 	}
+	c.jobs++
+	c.cpuSeconds += job.cpus * job.elapsed
+	c.gpuSeconds += job.gpus * job.elapsed
 
-	return nil
+	class, signal := job.ExitClass()
+	jc.exitCounts[exitKey{class: class, signal: strconv.Itoa(signal), partition: job.partition, account: job.account}]++
 }
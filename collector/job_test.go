@@ -1,21 +1,179 @@
 package collector
 
 import (
-	"github.com/stretchr/testify/assert"
-	"io"
-	"os"
+	"context"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestJobMetrics(t *testing.T) {
-	// Read the input data from a file
-	file, _ := os.Open("fixtures/sacct/job.txt")
-	data, _ := io.ReadAll(file)
-	metrics := ParseJobMetrics(nil, data)
-
-	assert.Equal(t, 115, len(metrics))
-	assert.Equal(t, "91193_761", metrics[0].JobID)
-	assert.Equal(t, "extract", metrics[0].JobName)
-	assert.Equal(t, "user1", metrics[0].User)
-	assert.Equal(t, 70839.0, metrics[0].Elapsed)
+func TestParseEndedJobs(t *testing.T) {
+	jobs := parseEndedJobs(readFixture(t, "fixtures/sacct/job.txt"), time.UTC)
+
+	require.Len(t, jobs, 119)
+	assert.Equal(t, EndedJob{
+		id:        "91193_761",
+		state:     "COMPLETED",
+		partition: "gpu",
+		account:   "account1",
+		user:      "user1",
+		end:       time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC).Unix(),
+		elapsed:   70839,
+		cpus:      1,
+		gpus:      1,
+	}, jobs[0])
+	// "CANCELLED by 1001"
+	assert.Equal(t, "CANCELLED", jobs[8].state)
+	assert.Equal(t, 0.0, jobs[1].gpus)
+
+	assert.Empty(t, ParseEndedJobs([]byte("1|COMPLETED|cpu|account1|user1|Unknown|0|1|cpu=1\nbroken line\n")))
+}
+
+func TestExitClass(t *testing.T) {
+	for _, tc := range []struct {
+		state, exitCode, derivedExitCode string
+		class                            string
+		signal                           int
+	}{
+		{"COMPLETED", "0:0", "0:0", "success", 0},
+		{"FAILED", "1:0", "1:0", "error", 0},
+		{"FAILED", "0:0", "2:0", "error", 0},
+		{"CANCELLED", "0:15", "0:0", "signal", 15},
+		{"FAILED", "0:0", "0:9", "signal", 9},
+		{"FAILED", "137:0", "0:0", "signal", 9},
+		{"OUT_OF_MEMORY", "0:125", "0:0", "oom", 125},
+	} {
+		job := EndedJob{state: tc.state, exitCode: parseExitCode(tc.exitCode), derivedExitCode: parseExitCode(tc.derivedExitCode)}
+		class, signal := job.ExitClass()
+		assert.Equal(t, tc.class, class, tc)
+		assert.Equal(t, tc.signal, signal, tc)
+	}
+}
+
+func TestParseElapsedTime(t *testing.T) {
+	for input, expected := range map[string]float64{
+		"00:00:59":   59,
+		"12:30":      750,
+		"3:02:01":    10921,
+		"1-14:42:29": 139349,
+	} {
+		elapsed, err := ParseElapsedTime(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, elapsed, input)
+	}
+
+	for _, input := range []string{"UNLIMITED", "", "1-", "x-01:00:00", "1:2:3:4"} {
+		_, err := ParseElapsedTime(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestJobCollectorStateFilePerCluster(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "job.state")
+	require.NoError(t, loadTestConfig(t, "collectors:\n  job:\n    options:\n      state_file: "+stateFile+"\n"))
+	now := time.Date(2024, 6, 20, 0, 0, 0, 0, time.Local)
+
+	for _, cluster := range []string{"north", "south"} {
+		c, err := NewJobCollector(log.NewNopLogger(), sharedClient{SlurmClient: fixtureClient{endedJobs: "fixtures/sacct/job.txt"}, cluster: cluster})
+		require.NoError(t, err)
+		require.NoError(t, c.(*JobCollector).update(context.Background(), now))
+		assert.FileExists(t, stateFile+"."+cluster)
+	}
+	assert.NoFileExists(t, stateFile)
+}
+
+func TestJobCollector(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "job.state")
+	require.NoError(t, loadTestConfig(t, "collectors:\n  job:\n    options:\n      state_file: "+stateFile+"\n"))
+	client := fixtureClient{endedJobs: "fixtures/sacct/job.txt"}
+	now := time.Date(2024, 6, 20, 0, 0, 0, 0, time.Local)
+	// the exporter ran before the jobs ended
+	earlier := jobCursor{Latest: time.Date(2024, 6, 18, 23, 0, 0, 0, time.Local).Unix(), Seen: map[string]int64{}}
+	require.NoError(t, writeJobCursor(stateFile, earlier))
+
+	c, err := NewJobCollector(log.NewNopLogger(), client)
+	require.NoError(t, err)
+	jc := c.(*JobCollector)
+	require.NoError(t, jc.update(context.Background(), now))
+	// sacct lists the jobs within the overlap again
+	require.NoError(t, jc.update(context.Background(), now.Add(time.Minute)))
+
+	expected := `
+# HELP slurm_jobs_ended_total Jobs which ended, by final state
+# TYPE slurm_jobs_ended_total counter
+slurm_jobs_ended_total{account="account1",partition="gpu",state="CANCELLED"} 3
+slurm_jobs_ended_total{account="account1",partition="gpu",state="COMPLETED"} 26
+slurm_jobs_ended_total{account="account1",partition="gpu",state="FAILED"} 4
+slurm_jobs_ended_total{account="account1",partition="gpu",state="OUT_OF_MEMORY"} 3
+slurm_jobs_ended_total{account="account1",partition="gpu",state="TIMEOUT"} 4
+slurm_jobs_ended_total{account="account2",partition="cpu",state="CANCELLED"} 4
+slurm_jobs_ended_total{account="account2",partition="cpu",state="COMPLETED"} 25
+slurm_jobs_ended_total{account="account2",partition="cpu",state="FAILED"} 4
+slurm_jobs_ended_total{account="account2",partition="cpu",state="OUT_OF_MEMORY"} 4
+slurm_jobs_ended_total{account="account2",partition="cpu",state="TIMEOUT"} 3
+slurm_jobs_ended_total{account="account3",partition="cpu",state="CANCELLED"} 4
+slurm_jobs_ended_total{account="account3",partition="cpu",state="COMPLETED"} 26
+slurm_jobs_ended_total{account="account3",partition="cpu",state="FAILED"} 3
+slurm_jobs_ended_total{account="account3",partition="cpu",state="OUT_OF_MEMORY"} 3
+slurm_jobs_ended_total{account="account3",partition="cpu",state="TIMEOUT"} 3
+`
+	rc := &registryCollector{Collector: jc}
+	assert.NoError(t, testutil.CollectAndCompare(rc, strings.NewReader(expected), "slurm_jobs_ended_total"))
+	assert.NoError(t, rc.err)
+
+	exits := `
+# HELP slurm_jobs_ended_exit_total Jobs which ended, by exit class and terminating signal
+# TYPE slurm_jobs_ended_exit_total counter
+slurm_jobs_ended_exit_total{account="account1",class="error",partition="gpu",signal="0"} 3
+slurm_jobs_ended_exit_total{account="account1",class="oom",partition="gpu",signal="125"} 3
+slurm_jobs_ended_exit_total{account="account1",class="signal",partition="gpu",signal="15"} 7
+slurm_jobs_ended_exit_total{account="account1",class="signal",partition="gpu",signal="9"} 1
+slurm_jobs_ended_exit_total{account="account1",class="success",partition="gpu",signal="0"} 26
+slurm_jobs_ended_exit_total{account="account2",class="error",partition="cpu",signal="0"} 3
+slurm_jobs_ended_exit_total{account="account2",class="oom",partition="cpu",signal="125"} 4
+slurm_jobs_ended_exit_total{account="account2",class="signal",partition="cpu",signal="15"} 7
+slurm_jobs_ended_exit_total{account="account2",class="signal",partition="cpu",signal="9"} 1
+slurm_jobs_ended_exit_total{account="account2",class="success",partition="cpu",signal="0"} 25
+slurm_jobs_ended_exit_total{account="account3",class="error",partition="cpu",signal="0"} 2
+slurm_jobs_ended_exit_total{account="account3",class="oom",partition="cpu",signal="125"} 3
+slurm_jobs_ended_exit_total{account="account3",class="signal",partition="cpu",signal="15"} 7
+slurm_jobs_ended_exit_total{account="account3",class="signal",partition="cpu",signal="9"} 1
+slurm_jobs_ended_exit_total{account="account3",class="success",partition="cpu",signal="0"} 26
+`
+	assert.NoError(t, testutil.CollectAndCompare(rc, strings.NewReader(exits), "slurm_jobs_ended_exit_total"))
+
+	assert.Equal(t, &endedJobsCounters{jobs: 26, cpuSeconds: 3873816, gpuSeconds: 1624836},
+		jc.counters[endedJobsKey{state: "COMPLETED", partition: "gpu", account: "account1"}])
+	assert.Equal(t, 15, testutil.CollectAndCount(rc, "slurm_jobs_ended_cpu_seconds_total"))
+	assert.Equal(t, 15, testutil.CollectAndCount(rc, "slurm_jobs_ended_gpu_seconds_total"))
+
+	// a new collector continues from the state file instead of counting
+	// the same jobs again
+	c, err = NewJobCollector(log.NewNopLogger(), client)
+	require.NoError(t, err)
+	restarted := c.(*JobCollector)
+	assert.Equal(t, jc.cursor, restarted.cursor)
+	require.NoError(t, restarted.update(context.Background(), now.Add(2*time.Minute)))
+	assert.Empty(t, restarted.counters)
+}
+
+func TestJobCollectorFirstRun(t *testing.T) {
+	// without a state file, the jobs which ended before the start are not
+	// counted
+	c, err := NewJobCollector(log.NewNopLogger(), fixtureClient{endedJobs: "fixtures/sacct/job.txt"})
+	require.NoError(t, err)
+	jc := c.(*JobCollector)
+	now := time.Date(2024, 6, 20, 0, 0, 0, 0, time.Local)
+	require.NoError(t, jc.update(context.Background(), now))
+	require.NoError(t, jc.update(context.Background(), now.Add(time.Minute)))
+
+	assert.Empty(t, jc.counters)
+	assert.Empty(t, jc.exitCounts)
+	assert.Equal(t, now.Unix(), jc.cursor.Latest)
 }
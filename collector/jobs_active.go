@@ -0,0 +1,139 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// finishedJobStates are the states squeue keeps reporting for a while after
+// a job ended, such jobs are not active anymore.
+var finishedJobStates = map[string]bool{
+	"BOOT_FAIL":     true,
+	"CANCELLED":     true,
+	"COMPLETED":     true,
+	"DEADLINE":      true,
+	"FAILED":        true,
+	"NODE_FAIL":     true,
+	"OUT_OF_MEMORY": true,
+	"PREEMPTED":     true,
+	"REVOKED":       true,
+	"TIMEOUT":       true,
+}
+
+// jobsActiveOptions are the options of the jobs_active collector in the
+// configuration file. Every job has its own series, they bound how many.
+type jobsActiveOptions struct {
+	// MaxJobs is the number of jobs exported at most, running jobs first,
+	// 0 exports all of them
+	MaxJobs int `yaml:"max_jobs"`
+	// OnlyRunning leaves out the jobs which are not running, e.g. pending
+	OnlyRunning bool `yaml:"only_running"`
+}
+
+func defaultJobsActiveOptions() interface{} {
+	return &jobsActiveOptions{MaxJobs: 1000}
+}
+
+// ActiveJobs returns the jobs which did not finish yet, or only the running
+// ones. If there are more than maxJobs of them, the running jobs are kept
+// first and the number of jobs left out is returned as well.
+func ActiveJobs(jobs []Job, onlyRunning bool, maxJobs int) ([]Job, int) {
+	var active []Job
+	for _, job := range jobs {
+		if finishedJobStates[job.state] || onlyRunning && job.state != "RUNNING" {
+			continue
+		}
+		active = append(active, job)
+	}
+
+	if maxJobs <= 0 || len(active) <= maxJobs {
+		return active, 0
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		return active[i].state == "RUNNING" && active[j].state != "RUNNING"
+	})
+	return active[:maxJobs], len(active) - maxJobs
+}
+
+type JobsActiveCollector struct {
+	cpus        *prometheus.Desc
+	memory      *prometheus.Desc
+	gpus        *prometheus.Desc
+	timeUsed    *prometheus.Desc
+	timeLeft    *prometheus.Desc
+	state       *prometheus.Desc
+	dropped     *prometheus.Desc
+	maxJobs     int
+	onlyRunning bool
+	client      SlurmClient
+	logger      log.Logger
+}
+
+func init() {
+	registerCollector("jobs_active", defaultDisabled, NewJobsActiveCollector)
+	registerCollectorOptions("jobs_active", defaultJobsActiveOptions)
+}
+
+func NewJobsActiveCollector(logger log.Logger, client SlurmClient) (Collector, error) {
+	options := defaultJobsActiveOptions().(*jobsActiveOptions)
+	if err := decodeOptions("jobs_active", options); err != nil {
+		return nil, err
+	}
+
+	labels := []string{"job_id", "user", "account", "partition", "qos"}
+	return &JobsActiveCollector{
+		client:      client,
+		logger:      logger,
+		maxJobs:     options.MaxJobs,
+		onlyRunning: options.OnlyRunning,
+		cpus:        prometheus.NewDesc("slurm_job_active_cpus", "CPUs allocated to the job", labels, nil),
+		memory:      prometheus.NewDesc("slurm_job_active_memory_bytes", "Memory allocated to the job, per node or per CPU as requested", labels, nil),
+		gpus:        prometheus.NewDesc("slurm_job_active_gpus", "GPUs allocated to the job", labels, nil),
+		timeUsed:    prometheus.NewDesc("slurm_job_active_time_used_seconds", "Time the job has been running", labels, nil),
+		timeLeft:    prometheus.NewDesc("slurm_job_active_time_left_seconds", "Time left until the job reaches its time limit, only for jobs with a limit", labels, nil),
+		state:       prometheus.NewDesc("slurm_job_active_state", "State of the job, always 1", append(labels, "state"), nil),
+		dropped:     prometheus.NewDesc("slurm_job_active_dropped", "Active jobs left out because of the max_jobs option", nil, nil),
+	}, nil
+}
+
+func (jc *JobsActiveCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	jobs, err := jc.client.Jobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	active, dropped := ActiveJobs(jobs, jc.onlyRunning, jc.maxJobs)
+	for _, job := range active {
+		labels := []string{job.id, job.user, job.account, job.partition, job.qos}
+		ch <- prometheus.MustNewConstMetric(jc.cpus, prometheus.GaugeValue, job.cpus, labels...)
+		ch <- prometheus.MustNewConstMetric(jc.memory, prometheus.GaugeValue, job.memory, labels...)
+		ch <- prometheus.MustNewConstMetric(jc.gpus, prometheus.GaugeValue, job.gpus, labels...)
+		ch <- prometheus.MustNewConstMetric(jc.timeUsed, prometheus.GaugeValue, job.timeUsed, labels...)
+		if job.timeLimited {
+			ch <- prometheus.MustNewConstMetric(jc.timeLeft, prometheus.GaugeValue, job.timeLeft, labels...)
+		}
+		ch <- prometheus.MustNewConstMetric(jc.state, prometheus.GaugeValue, 1, append(labels, job.state)...)
+	}
+	ch <- prometheus.MustNewConstMetric(jc.dropped, prometheus.GaugeValue, float64(dropped))
+
+	return nil
+}
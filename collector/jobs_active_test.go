@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseActiveJobFields(t *testing.T) {
+	jobs := ParseJobs(readFixture(t, "fixtures/squeue/jobs_active.txt"))
+	require.Len(t, jobs, 6)
+
+	assert.Equal(t, "normal", jobs[0].qos)
+	assert.Equal(t, 93784.0, jobs[0].timeUsed)
+	assert.Equal(t, 79016.0, jobs[0].timeLeft)
+	assert.True(t, jobs[0].timeLimited)
+	assert.Equal(t, 4.0, jobs[0].gpus)
+
+	assert.Equal(t, 750.0, jobs[1].timeUsed)
+	assert.False(t, jobs[1].timeLimited)
+	assert.Equal(t, 0.0, jobs[1].gpus)
+
+	assert.Equal(t, 1.0, jobs[3].gpus)
+	assert.Equal(t, 2.0, jobs[5].gpus)
+}
+
+func TestActiveJobs(t *testing.T) {
+	jobs := ParseJobs(readFixture(t, "fixtures/squeue/jobs_active.txt"))
+
+	ids := func(jobs []Job) []string {
+		var ids []string
+		for _, job := range jobs {
+			ids = append(ids, job.id)
+		}
+		return ids
+	}
+
+	active, dropped := ActiveJobs(jobs, false, 0)
+	assert.Equal(t, []string{"94501", "94502", "94503", "94504", "94506"}, ids(active))
+	assert.Equal(t, 0, dropped)
+
+	active, dropped = ActiveJobs(jobs, true, 0)
+	assert.Equal(t, []string{"94501", "94502"}, ids(active))
+	assert.Equal(t, 0, dropped)
+
+	// running jobs are kept first
+	active, dropped = ActiveJobs(jobs, false, 3)
+	assert.Equal(t, []string{"94501", "94502", "94503"}, ids(active))
+	assert.Equal(t, 2, dropped)
+}
+
+func TestJobsActiveCollector(t *testing.T) {
+	require.NoError(t, loadTestConfig(t, "collectors:\n  jobs_active:\n    options:\n      max_jobs: 4\n"))
+	jc, err := NewJobsActiveCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/jobs_active.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_job_active_dropped Active jobs left out because of the max_jobs option
+# TYPE slurm_job_active_dropped gauge
+slurm_job_active_dropped 1
+# HELP slurm_job_active_gpus GPUs allocated to the job
+# TYPE slurm_job_active_gpus gauge
+slurm_job_active_gpus{account="account1",job_id="94501",partition="gpu",qos="normal",user="user1"} 4
+slurm_job_active_gpus{account="account1",job_id="94504",partition="gpu",qos="normal",user="user3"} 1
+slurm_job_active_gpus{account="account2",job_id="94502",partition="cpu",qos="long",user="user2"} 0
+slurm_job_active_gpus{account="account2",job_id="94503",partition="cpu",qos="long",user="user2"} 0
+# HELP slurm_job_active_time_left_seconds Time left until the job reaches its time limit, only for jobs with a limit
+# TYPE slurm_job_active_time_left_seconds gauge
+slurm_job_active_time_left_seconds{account="account1",job_id="94501",partition="gpu",qos="normal",user="user1"} 79016
+slurm_job_active_time_left_seconds{account="account1",job_id="94504",partition="gpu",qos="normal",user="user3"} 3600
+slurm_job_active_time_left_seconds{account="account2",job_id="94503",partition="cpu",qos="long",user="user2"} 172800
+`
+	c := &registryCollector{Collector: jc}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"slurm_job_active_dropped", "slurm_job_active_gpus", "slurm_job_active_time_left_seconds"))
+	assert.NoError(t, c.err)
+	assert.Equal(t, 4, testutil.CollectAndCount(c, "slurm_job_active_state"))
+}
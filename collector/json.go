@@ -0,0 +1,744 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The types below decode the JSON documents served by slurmrestd and printed
+// by the command line tools with --json. Their layout changed between the
+// Slurm versions, the decoders accept both the older plain values and the
+// newer structured ones.
+
+// jsonNumber is either a plain number or a {"set", "infinite", "number"}
+// object, unset and infinite values decode to 0.
+type jsonNumber float64
+
+func (n *jsonNumber) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err == nil {
+		*n = jsonNumber(value)
+		return nil
+	}
+	var object struct {
+		Set      bool    `json:"set"`
+		Infinite bool    `json:"infinite"`
+		Number   float64 `json:"number"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("invalid number %s: %w", data, err)
+	}
+	*n = 0
+	if object.Set && !object.Infinite {
+		*n = jsonNumber(object.Number)
+	}
+	return nil
+}
+
+// jsonSetNumber is a jsonNumber which tells whether it was set, for values
+// which are unknown rather than 0 when unset.
+type jsonSetNumber struct {
+	value jsonNumber
+	set   bool
+}
+
+// slurmNoVal is the unset value of the plain 32 bit numbers.
+const slurmNoVal = 0xfffffffe
+
+func (n *jsonSetNumber) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err == nil {
+		*n = jsonSetNumber{value: jsonNumber(value), set: value < slurmNoVal}
+		return nil
+	}
+	var object struct {
+		Set      bool `json:"set"`
+		Infinite bool `json:"infinite"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("invalid number %s: %w", data, err)
+	}
+	n.set = object.Set && !object.Infinite
+	return n.value.UnmarshalJSON(data)
+}
+
+func (n jsonNumber) String() string {
+	return strconv.FormatFloat(float64(n), 'f', -1, 64)
+}
+
+// jsonStrings is either a single string or a list of strings, used for
+// states which turned into a list of flags in newer versions.
+type jsonStrings []string
+
+func (s *jsonStrings) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*s = nil
+		if value != "" {
+			*s = []string{value}
+		}
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("invalid string list %s: %w", data, err)
+	}
+	*s = values
+	return nil
+}
+
+// jsonError is an entry of the "errors" list of every response.
+type jsonError struct {
+	Description string     `json:"description"`
+	Error       string     `json:"error"`
+	ErrorNumber jsonNumber `json:"error_number"`
+}
+
+type jsonResponse struct {
+	Errors []jsonError `json:"errors"`
+}
+
+// err returns the first error reported in the response, if any.
+func (r jsonResponse) err() error {
+	for _, e := range r.Errors {
+		if e.ErrorNumber != 0 || e.Error != "" {
+			return fmt.Errorf("slurm error %v: %s %s", e.ErrorNumber, e.Error, e.Description)
+		}
+	}
+	return nil
+}
+
+type jsonJob struct {
+	JobID         jsonNumber  `json:"job_id"`
+	UserName      string      `json:"user_name"`
+	Account       string      `json:"account"`
+	Partition     string      `json:"partition"`
+	JobState      jsonStrings `json:"job_state"`
+	CPUs          jsonNumber  `json:"cpus"`
+	MemoryPerNode jsonNumber  `json:"memory_per_node"`
+	MemoryPerCPU  jsonNumber  `json:"memory_per_cpu"`
+	StateReason   string      `json:"state_reason"`
+	QOS           string      `json:"qos"`
+	StartTime     jsonNumber  `json:"start_time"`
+	EndTime       jsonNumber  `json:"end_time"`
+	TimeLimit     jsonNumber  `json:"time_limit"`
+	TresPerNode   string      `json:"tres_per_node"`
+	NodeCount     jsonNumber  `json:"node_count"`
+	SubmitTime    jsonNumber  `json:"submit_time"`
+	// ArrayTaskString lists the pending tasks of an array, which are not
+	// listed one by one
+	ArrayJobID      jsonNumber `json:"array_job_id"`
+	ArrayTaskString string     `json:"array_task_string"`
+	HetJobID        jsonNumber `json:"het_job_id"`
+	HetJobOffset    jsonNumber `json:"het_job_offset"`
+}
+
+type jsonJobs struct {
+	jsonResponse
+	Jobs []jsonJob `json:"jobs"`
+}
+
+// ParseJobsJSON converts a list of jobs into the records used by the
+// collectors, matching the values of ParseJobs.
+func ParseJobsJSON(input []byte) ([]Job, error) {
+	return parseJobsJSON(input, time.Now())
+}
+
+// parseJobsJSON is ParseJobsJSON with the time the time used and left of
+// the jobs are computed at, which squeue computes itself in text.
+func parseJobsJSON(input []byte, now time.Time) ([]Job, error) {
+	var response jsonJobs
+	if err := json.Unmarshal(input, &response); err != nil {
+		return nil, fmt.Errorf("decode jobs: %w", err)
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]Job, 0, len(response.Jobs))
+	for _, j := range response.Jobs {
+		// squeue reports the memory per CPU when it was requested that way
+		memory := j.MemoryPerNode
+		if memory == 0 {
+			memory = j.MemoryPerCPU
+		}
+		var state string
+		if len(j.JobState) > 0 {
+			state = j.JobState[0]
+		}
+		job := Job{
+			id:        j.JobID.String(),
+			user:      j.UserName,
+			account:   j.Account,
+			partition: j.Partition,
+			state:     state,
+			cpus:      float64(j.CPUs),
+			memory:    float64(memory) * 1024 * 1024,
+			reason:    j.StateReason,
+			qos:       j.QOS,
+			gpus:      jobGPUs(j.TresPerNode, float64(j.NodeCount)),
+			submit:    int64(j.SubmitTime),
+		}
+		if j.ArrayJobID != 0 {
+			job.arrayJobID = j.ArrayJobID.String()
+			if strings.ContainsAny(j.ArrayTaskString, ",-") {
+				job.arrayTasks = countArrayTasks(j.ArrayTaskString)
+			}
+		}
+		if j.HetJobID != 0 {
+			job.hetJobID = j.HetJobID.String()
+			job.hetOffset = int(j.HetJobOffset)
+		}
+		// the start of a pending job is when it is expected to start, the
+		// end of a running job is when its time limit is reached
+		start, end := float64(j.StartTime), float64(now.Unix())
+		if j.EndTime > 0 && float64(j.EndTime) < end {
+			end = float64(j.EndTime)
+		}
+		if start > 0 && start < end {
+			job.timeUsed = end - start
+		}
+		if state == "PENDING" {
+			job.expectedStart = int64(start)
+		}
+		if j.TimeLimit > 0 {
+			job.timeLimited = true
+			job.timeLeft = math.Max(float64(j.TimeLimit)*60-job.timeUsed, 0)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+type jsonNode struct {
+	Name        string      `json:"name"`
+	Partitions  []string    `json:"partitions"`
+	State       jsonStrings `json:"state"`
+	StateFlags  []string    `json:"state_flags"`
+	CPUs        jsonNumber  `json:"cpus"`
+	AllocCPUs   jsonNumber  `json:"alloc_cpus"`
+	AllocMemory jsonNumber  `json:"alloc_memory"`
+	RealMemory  jsonNumber  `json:"real_memory"`
+	Gres        string      `json:"gres"`
+	GresUsed    string      `json:"gres_used"`
+	// Features are a comma separated string before Slurm 23.02
+	Features       jsonStrings `json:"features"`
+	ActiveFeatures jsonStrings `json:"active_features"`
+	// CPULoad is the load multiplied by 100
+	CPULoad           jsonSetNumber `json:"cpu_load"`
+	FreeMemory        jsonSetNumber `json:"free_mem"`
+	SpecializedMemory jsonNumber    `json:"specialized_memory"`
+	SpecializedCores  jsonNumber    `json:"specialized_cores"`
+	Energy            struct {
+		AverageWatts   jsonNumber    `json:"average_watts"`
+		ConsumedEnergy jsonNumber    `json:"consumed_energy"`
+		CurrentWatts   jsonSetNumber `json:"current_watts"`
+	} `json:"energy"`
+	Reason          string     `json:"reason"`
+	ReasonSetByUser string     `json:"reason_set_by_user"`
+	ReasonChangedAt jsonNumber `json:"reason_changed_at"`
+}
+
+type jsonNodes struct {
+	jsonResponse
+	Nodes []jsonNode `json:"nodes"`
+}
+
+// ParseNodesJSON converts a list of nodes into the records used by the
+// collectors, matching the values of ParseNodes.
+func ParseNodesJSON(input []byte) ([]Node, error) {
+	var response jsonNodes
+	if err := json.Unmarshal(input, &response); err != nil {
+		return nil, fmt.Errorf("decode nodes: %w", err)
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(response.Nodes))
+	for _, n := range response.Nodes {
+		// older versions report the flags apart from the base state
+		flags := append(append([]string{}, n.State...), n.StateFlags...)
+
+		node := Node{
+			name:           n.Name,
+			partitions:     n.Partitions,
+			memAlloc:       float64(n.AllocMemory),
+			memTotal:       float64(n.RealMemory),
+			nodeStatus:     nodeStateLong(flags),
+			features:       parseList(n.Features...),
+			activeFeatures: parseList(n.ActiveFeatures...),
+		}
+		node.state, node.flags = parseNodeState(flags)
+		// sinfo counts the unallocated CPUs of unavailable nodes as other
+		node.cpu.total = float64(n.CPUs)
+		node.cpu.alloc = float64(n.AllocCPUs)
+		if hasFlag(flags, "DRAIN", "DOWN", "FAIL") {
+			node.cpu.other = node.cpu.total - node.cpu.alloc
+		} else {
+			node.cpu.idle = node.cpu.total - node.cpu.alloc
+		}
+		if n.Gres != "" && n.Gres != "(null)" {
+			node.gres = ParseGenericResources(n.Gres)
+			node.gresUsed = ParseGenericResources(n.GresUsed)
+		}
+		nodes = append(nodes, node)
+	}
+	sortNodes(nodes)
+	return nodes, nil
+}
+
+// ParseNodeReasonsJSON converts a list of nodes into the reasons of the
+// nodes which are down, drained or failing, matching the values of
+// ParseNodeReasons.
+func ParseNodeReasonsJSON(input []byte) ([]NodeReason, error) {
+	var response jsonNodes
+	if err := json.Unmarshal(input, &response); err != nil {
+		return nil, fmt.Errorf("decode nodes: %w", err)
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	var reasons []NodeReason
+	for _, n := range response.Nodes {
+		flags := append(append([]string{}, n.State...), n.StateFlags...)
+		if n.Reason == "" || !hasFlag(flags, "DRAIN", "DOWN", "FAIL") {
+			continue
+		}
+		reasons = append(reasons, NodeReason{
+			node:   n.Name,
+			state:  nodeStateLong(flags),
+			user:   n.ReasonSetByUser,
+			reason: n.Reason,
+			since:  int64(n.ReasonChangedAt),
+		})
+	}
+	return reasons, nil
+}
+
+// ParseNodeDetailsJSON converts a list of nodes into the details used by the
+// node collector, matching the values of ParseNodeDetails.
+func ParseNodeDetailsJSON(input []byte) ([]NodeDetails, error) {
+	var response jsonNodes
+	if err := json.Unmarshal(input, &response); err != nil {
+		return nil, fmt.Errorf("decode nodes: %w", err)
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]NodeDetails, 0, len(response.Nodes))
+	for _, n := range response.Nodes {
+		node := NodeDetails{
+			name:          n.Name,
+			partitions:    n.Partitions,
+			realMemory:    float64(n.RealMemory),
+			memSpecLimit:  float64(n.SpecializedMemory),
+			coreSpecCount: float64(n.SpecializedCores),
+		}
+		if n.CPULoad.set {
+			node.cpuLoad, node.hasCPULoad = float64(n.CPULoad.value)/100, true
+		}
+		if n.FreeMemory.set {
+			node.freeMem, node.hasFreeMem = float64(n.FreeMemory.value), true
+		}
+		if n.Energy.CurrentWatts.set {
+			node.hasEnergy = true
+			node.currentWatts = float64(n.Energy.CurrentWatts.value)
+			node.averageWatts = float64(n.Energy.AverageWatts)
+			node.consumedJoules = float64(n.Energy.ConsumedEnergy)
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+	return nodes, nil
+}
+
+func hasFlag(flags []string, names ...string) bool {
+	for _, flag := range flags {
+		for _, name := range names {
+			if strings.EqualFold(flag, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nodeStateLong renders a base state and its flags the way sinfo prints
+// them with StateLong, e.g. "drained*" for an idle, drained and not
+// responding node.
+func nodeStateLong(flags []string) string {
+	var base string
+	if len(flags) > 0 {
+		base = strings.ToLower(flags[0])
+	}
+	busy := base == "allocated" || base == "mixed" || hasFlag(flags, "COMPLETING")
+
+	var state string
+	switch {
+	case hasFlag(flags, "DRAIN"):
+		if busy {
+			state = "draining"
+		} else {
+			state = "drained"
+		}
+	case hasFlag(flags, "FAIL"):
+		if busy {
+			state = "failing"
+		} else {
+			state = "fail"
+		}
+	case hasFlag(flags, "MAINTENANCE"):
+		state = "maint"
+	case base == "idle" && hasFlag(flags, "COMPLETING"):
+		state = "completing"
+	case base == "idle" && hasFlag(flags, "RESERVED"):
+		state = "reserved"
+	case base == "idle" && hasFlag(flags, "PLANNED"):
+		state = "planned"
+	default:
+		state = base
+	}
+
+	switch {
+	case hasFlag(flags, "NOT_RESPONDING"):
+		state += "*"
+	case hasFlag(flags, "POWERED_DOWN"):
+		state += "~"
+	case hasFlag(flags, "POWERING_UP"):
+		state += "#"
+	case hasFlag(flags, "POWERING_DOWN"):
+		state += "%"
+	case hasFlag(flags, "POWER_DOWN"):
+		state += "!"
+	case hasFlag(flags, "REBOOT_REQUESTED"):
+		state += "@"
+	}
+	return state
+}
+
+type jsonDiag struct {
+	jsonResponse
+	Statistics struct {
+		ServerThreadCount      jsonNumber `json:"server_thread_count"`
+		AgentQueueSize         jsonNumber `json:"agent_queue_size"`
+		DBDAgentQueueSize      jsonNumber `json:"dbd_agent_queue_size"`
+		ScheduleCycleLast      jsonNumber `json:"schedule_cycle_last"`
+		ScheduleCycleMean      jsonNumber `json:"schedule_cycle_mean"`
+		ScheduleCyclePerMinute jsonNumber `json:"schedule_cycle_per_minute"`
+		BfCycleLast            jsonNumber `json:"bf_cycle_last"`
+		BfCycleMean            jsonNumber `json:"bf_cycle_mean"`
+		BfDepthMean            jsonNumber `json:"bf_depth_mean"`
+		BfBackfilledJobs       jsonNumber `json:"bf_backfilled_jobs"`
+		BfLastBackfilledJobs   jsonNumber `json:"bf_last_backfilled_jobs"`
+		BfBackfilledHetJobs    jsonNumber `json:"bf_backfilled_het_jobs"`
+	} `json:"statistics"`
+}
+
+// ParseSchedulerMetricsJSON converts the scheduler statistics into the
+// values of ParseSchedulerMetrics.
+func ParseSchedulerMetricsJSON(input []byte) (*SchedulerMetrics, error) {
+	var response jsonDiag
+	if err := json.Unmarshal(input, &response); err != nil {
+		return nil, fmt.Errorf("decode diag: %w", err)
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	s := response.Statistics
+	return &SchedulerMetrics{
+		threads:                       float64(s.ServerThreadCount),
+		queueSize:                     float64(s.AgentQueueSize),
+		dbdQueueSize:                  float64(s.DBDAgentQueueSize),
+		lastCycle:                     float64(s.ScheduleCycleLast),
+		meanCycle:                     float64(s.ScheduleCycleMean),
+		cyclePerMinute:                float64(s.ScheduleCyclePerMinute),
+		backfillLastCycle:             float64(s.BfCycleLast),
+		backfillMeanCycle:             float64(s.BfCycleMean),
+		backfillDepthMean:             float64(s.BfDepthMean),
+		totalBackfilledJobsSinceStart: float64(s.BfBackfilledJobs),
+		totalBackfilledJobsSinceCycle: float64(s.BfLastBackfilledJobs),
+		totalBackfilledHeterogeneous:  float64(s.BfBackfilledHetJobs),
+	}, nil
+}
+
+type jsonShare struct {
+	Name      string      `json:"name"`
+	Parent    string      `json:"parent"`
+	Type      jsonStrings `json:"type"`
+	Fairshare struct {
+		Factor jsonNumber `json:"factor"`
+	} `json:"fairshare"`
+}
+
+type jsonShares struct {
+	jsonResponse
+	Shares struct {
+		Shares []jsonShare `json:"shares"`
+	} `json:"shares"`
+}
+
+// ParseFairShareMetricsJSON converts the share tree into the values of
+// ParseFairShareMetrics, which only covers the root and its children.
+func ParseFairShareMetricsJSON(input []byte) (map[string]*FairShareMetrics, error) {
+	var response jsonShares
+	if err := json.Unmarshal(input, &response); err != nil {
+		return nil, fmt.Errorf("decode shares: %w", err)
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	accounts := make(map[string]*FairShareMetrics)
+	for _, share := range response.Shares.Shares {
+		if share.Parent != "" && share.Parent != "root" {
+			continue
+		}
+		accounts[share.Name] = &FairShareMetrics{fairshare: float64(share.Fairshare.Factor)}
+	}
+	return accounts, nil
+}
+
+type jsonAccountingJob struct {
+	JobID     jsonNumber `json:"job_id"`
+	User      string     `json:"user"`
+	Account   string     `json:"account"`
+	Partition string     `json:"partition"`
+	QOS       string     `json:"qos"`
+	Array     struct {
+		JobID  jsonNumber `json:"job_id"`
+		TaskID jsonNumber `json:"task_id"`
+	} `json:"array"`
+	State struct {
+		Current jsonStrings `json:"current"`
+	} `json:"state"`
+	Time struct {
+		Elapsed    jsonNumber `json:"elapsed"`
+		End        jsonNumber `json:"end"`
+		Submission jsonNumber `json:"submission"`
+		Start      jsonNumber `json:"start"`
+		Total      struct {
+			Seconds      jsonNumber `json:"seconds"`
+			Microseconds jsonNumber `json:"microseconds"`
+		} `json:"total"`
+	} `json:"time"`
+	// Required holds the memory per node before Slurm 23.02, per node or
+	// per CPU since, in MiB
+	Required struct {
+		Memory        jsonNumber `json:"memory"`
+		MemoryPerNode jsonNumber `json:"memory_per_node"`
+		MemoryPerCPU  jsonNumber `json:"memory_per_cpu"`
+	} `json:"required"`
+	Steps []struct {
+		Tasks struct {
+			Count jsonNumber `json:"count"`
+		} `json:"tasks"`
+		TRES struct {
+			Requested struct {
+				Max     []jsonTRES `json:"max"`
+				Average []jsonTRES `json:"average"`
+			} `json:"requested"`
+		} `json:"tres"`
+	} `json:"steps"`
+	ExitCode        jsonExitCode `json:"exit_code"`
+	DerivedExitCode jsonExitCode `json:"derived_exit_code"`
+	TRES            struct {
+		Allocated []jsonTRES `json:"allocated"`
+	} `json:"tres"`
+}
+
+type jsonTRES struct {
+	Type  string     `json:"type"`
+	Name  string     `json:"name"`
+	Count jsonNumber `json:"count"`
+}
+
+// tresMemory returns the memory in a list of TRES, in bytes.
+func tresMemory(list []jsonTRES) float64 {
+	for _, tres := range list {
+		if tres.Type == "mem" {
+			return float64(tres.Count)
+		}
+	}
+	return 0
+}
+
+// jsonExitCode is an exit code, whose signal is named signal_id before
+// Slurm 23.02 and id since.
+type jsonExitCode struct {
+	ReturnCode jsonNumber `json:"return_code"`
+	Signal     struct {
+		SignalID jsonSetNumber `json:"signal_id"`
+		ID       jsonSetNumber `json:"id"`
+	} `json:"signal"`
+}
+
+// exitCode keeps the return code and the signal apart, like the code:signal
+// printed by sacct.
+func (e jsonExitCode) exitCode() exitCode {
+	ec := exitCode{code: int(e.ReturnCode)}
+	switch {
+	case e.Signal.ID.set:
+		ec.signal = int(e.Signal.ID.value)
+	case e.Signal.SignalID.set:
+		ec.signal = int(e.Signal.SignalID.value)
+	}
+	return ec
+}
+
+type jsonAccountingJobs struct {
+	jsonResponse
+	Jobs []jsonAccountingJob `json:"jobs"`
+}
+
+// ParseEndedJobsJSON converts the jobs listed by sacct into the values of
+// ParseEndedJobs.
+func ParseEndedJobsJSON(input []byte) ([]EndedJob, error) {
+	var response jsonAccountingJobs
+	if err := json.Unmarshal(input, &response); err != nil {
+		return nil, fmt.Errorf("decode accounting jobs: %w", err)
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]EndedJob, 0, len(response.Jobs))
+	for _, j := range response.Jobs {
+		// sacct names array tasks after the array job, e.g. 91193_761
+		id := j.JobID.String()
+		if j.Array.JobID != 0 {
+			id = j.Array.JobID.String() + "_" + j.Array.TaskID.String()
+		}
+		job := EndedJob{
+			id:        id,
+			partition: j.Partition,
+			account:   j.Account,
+			user:      j.User,
+			end:       int64(j.Time.End),
+			elapsed:   float64(j.Time.Elapsed),
+
+			exitCode:        j.ExitCode.exitCode(),
+			derivedExitCode: j.DerivedExitCode.exitCode(),
+		}
+		if len(j.State.Current) > 0 {
+			job.state = j.State.Current[0]
+		}
+		for _, tres := range j.TRES.Allocated {
+			switch {
+			case tres.Type == "cpu":
+				job.cpus = float64(tres.Count)
+			case tres.Type == "gres" && tres.Name == "gpu":
+				job.gpus = float64(tres.Count)
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// ParseStartedJobsJSON converts the jobs listed by sacct into the values of
+// ParseStartedJobs.
+func ParseStartedJobsJSON(input []byte) ([]StartedJob, error) {
+	var response jsonAccountingJobs
+	if err := json.Unmarshal(input, &response); err != nil {
+		return nil, fmt.Errorf("decode accounting jobs: %w", err)
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]StartedJob, 0, len(response.Jobs))
+	for _, j := range response.Jobs {
+		if j.Time.Start == 0 {
+			continue
+		}
+		id := j.JobID.String()
+		if j.Array.JobID != 0 {
+			id = j.Array.JobID.String() + "_" + j.Array.TaskID.String()
+		}
+		jobs = append(jobs, StartedJob{
+			id:        id,
+			partition: j.Partition,
+			qos:       j.QOS,
+			submit:    int64(j.Time.Submission),
+			start:     int64(j.Time.Start),
+		})
+	}
+	return jobs, nil
+}
+
+// ParseJobUsageJSON converts the jobs listed by sacct into the values of
+// ParseJobUsage.
+func ParseJobUsageJSON(input []byte) ([]JobUsage, error) {
+	var response jsonAccountingJobs
+	if err := json.Unmarshal(input, &response); err != nil {
+		return nil, fmt.Errorf("decode accounting jobs: %w", err)
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]JobUsage, 0, len(response.Jobs))
+	for _, j := range response.Jobs {
+		id := j.JobID.String()
+		if j.Array.JobID != 0 {
+			id = j.Array.JobID.String() + "_" + j.Array.TaskID.String()
+		}
+		job := JobUsage{
+			id:        id,
+			partition: j.Partition,
+			account:   j.Account,
+			user:      j.User,
+			end:       int64(j.Time.End),
+			elapsed:   float64(j.Time.Elapsed),
+			totalCPU:  float64(j.Time.Total.Seconds) + float64(j.Time.Total.Microseconds)/1e6,
+		}
+		var nodes float64
+		for _, tres := range j.TRES.Allocated {
+			switch tres.Type {
+			case "cpu":
+				job.cpus = float64(tres.Count)
+			case "node":
+				nodes = float64(tres.Count)
+			}
+		}
+		for _, step := range j.Steps {
+			used := stepMemory(tresMemory(step.TRES.Requested.Max), tresMemory(step.TRES.Requested.Average), float64(step.Tasks.Count))
+			job.memUsed = math.Max(job.memUsed, used)
+		}
+		switch {
+		case j.Required.MemoryPerCPU > 0:
+			job.reqMem = float64(j.Required.MemoryPerCPU) * 1024 * 1024 * job.cpus
+		case j.Required.MemoryPerNode > 0:
+			job.reqMem = float64(j.Required.MemoryPerNode) * 1024 * 1024 * math.Max(nodes, 1)
+		default:
+			job.reqMem = float64(j.Required.Memory) * 1024 * 1024 * math.Max(nodes, 1)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
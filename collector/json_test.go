@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The JSON fixtures of every Slurm version hold the same data as the text
+// fixtures, so both must parse into the same values.
+func TestParseJSONFixtures(t *testing.T) {
+	nodesFixtures := map[string]string{
+		"21.08.5": "fixtures/sinfo/slurm-21.08.5/nodes.json",
+		"23.11.4": "fixtures/scontrol/slurm-23.11.4/nodes.json",
+	}
+
+	for version, nodesFixture := range nodesFixtures {
+		t.Run(version, func(t *testing.T) {
+			jobs, err := ParseJobsJSON(readFixture(t, fmt.Sprintf("fixtures/squeue/slurm-%s/jobs.json", version)))
+			require.NoError(t, err)
+			assert.Equal(t, ParseJobs(readFixture(t, "fixtures/squeue/user.txt")), jobs)
+
+			nodes, err := ParseNodesJSON(readFixture(t, nodesFixture))
+			require.NoError(t, err)
+			assert.Equal(t, ParseNodes(readFixture(t, "fixtures/sinfo/node.txt")), nodes)
+
+			diag, err := ParseSchedulerMetricsJSON(readFixture(t, fmt.Sprintf("fixtures/sdiag/slurm-%s/sdiag.json", version)))
+			require.NoError(t, err)
+			assert.Equal(t, ParseSchedulerMetrics(readFixture(t, "fixtures/sdiag/sdiag.txt")), diag)
+
+			ended, err := ParseEndedJobsJSON(readFixture(t, fmt.Sprintf("fixtures/sacct/slurm-%s/job.json", version)))
+			require.NoError(t, err)
+			// the fixture was recorded in UTC
+			assert.Equal(t, parseEndedJobs(readFixture(t, "fixtures/sacct/job.txt"), time.UTC), ended)
+		})
+	}
+}
+
+// The time used and left are computed from the start and end of the jobs,
+// where squeue prints them in text. The start of a pending job is when it
+// is expected to start.
+func TestParseJobsJSONTimes(t *testing.T) {
+	jobs, err := parseJobsJSON(readFixture(t, "fixtures/squeue/slurm-23.11.4/jobs_active.json"), time.Unix(1700000000, 0))
+	require.NoError(t, err)
+	// the fixture was recorded in UTC
+	assert.Equal(t, parseJobs(readFixture(t, "fixtures/squeue/jobs_active.txt"), time.UTC), jobs)
+}
+
+func TestParseJobsJSONHeterogeneous(t *testing.T) {
+	jobs, err := ParseJobsJSON([]byte(`{"jobs": [
+		{"job_id": 97000, "job_state": ["RUNNING"], "het_job_id": {"set": true, "number": 97000}, "het_job_offset": {"set": true, "number": 0}},
+		{"job_id": 97001, "job_state": ["RUNNING"], "het_job_id": {"set": true, "number": 97000}, "het_job_offset": {"set": true, "number": 1}},
+		{"job_id": 97020, "job_state": ["PENDING"], "het_job_id": {"set": false, "number": 0}, "het_job_offset": {"set": false, "number": 0}}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+	assert.Equal(t, "97000", jobs[1].hetJobID)
+	assert.Equal(t, 1, jobs[1].hetOffset)
+	assert.Equal(t, 0.0, jobs[1].count())
+	assert.Equal(t, "97000", jobs[0].hetJobID)
+	assert.Equal(t, 1.0, jobs[0].count())
+	assert.Equal(t, "", jobs[2].hetJobID)
+}
+
+func TestParseJSONExitCode(t *testing.T) {
+	for input, expected := range map[string]exitCode{
+		`{"return_code": 0, "signal": {"signal_id": 9}}`: {signal: 9},
+		`{"return_code": 9}`:                             {code: 9},
+		`{"return_code": {"set": true, "number": 0}, "signal": {"id": {"set": true, "number": 9}}}`:  {signal: 9},
+		`{"return_code": {"set": true, "number": 9}, "signal": {"id": {"set": false, "number": 0}}}`: {code: 9},
+		`{"return_code": 0, "signal": {"signal_id": 9, "id": {"set": true, "number": 9}}}`:           {signal: 9},
+	} {
+		var ec jsonExitCode
+		require.NoError(t, json.Unmarshal([]byte(input), &ec))
+		assert.Equal(t, expected, ec.exitCode(), input)
+	}
+}
+
+func TestParseJSONError(t *testing.T) {
+	_, err := ParseJobsJSON([]byte(`{"errors": [{"error": "Invalid user", "error_number": 2002}], "jobs": []}`))
+	assert.ErrorContains(t, err, "Invalid user")
+}
@@ -18,55 +18,130 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
+	"context"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// NodeMetrics stores metrics for each node
-type NodeMetrics struct {
-	memAlloc   float64
-	memTotal   float64
-	cpu        CPUs
-	gres       []GenericResource
-	gresUsed   []GenericResource
-	nodeStatus string
+// NodeDetails are the usage and limits of a node reported by scontrol, as
+// opposed to what Slurm allocated. Memory sizes are in MB, like in Node.
+type NodeDetails struct {
+	name       string
+	partitions []string
+	// cpuLoad and freeMem are unknown for nodes which do not respond
+	cpuLoad       float64
+	hasCPULoad    bool
+	freeMem       float64
+	hasFreeMem    bool
+	realMemory    float64
+	memSpecLimit  float64
+	coreSpecCount float64
+	// the energy is only known with an AcctGatherEnergyType, joules are
+	// consumed since slurmd started
+	hasEnergy      bool
+	currentWatts   float64
+	averageWatts   float64
+	consumedJoules float64
 }
 
-// ParseNodeMetrics takes the output of sinfo with node data
-func ParseNodeMetrics(input []byte) map[string]*NodeMetrics {
-	nodes := make(map[string]*NodeMetrics)
+// scontrolField matches the start of a Key=Value field of scontrol show -o,
+// the values may contain spaces, e.g. OS=Linux 5.14.0 #1 SMP.
+var scontrolField = regexp.MustCompile(`(?:^|\s)([A-Za-z][A-Za-z0-9_]*)=`)
 
-	lines := SplitLines(input)
-	// Sort and remove all the duplicates from the 'sinfo' output
-	sort.Strings(lines)
-	linesUniq := RemoveDuplicates(lines)
-
-	for _, line := range linesUniq {
-		node := strings.Fields(line)
-		nodeName := node[0]
-		nodeStatus := node[4] // mixed, allocated, etc.
+// parseScontrolFields splits a line of scontrol show -o into its fields.
+func parseScontrolFields(line string) map[string]string {
+	fields := make(map[string]string)
+	matches := scontrolField.FindAllStringSubmatchIndex(line, -1)
+	for i, match := range matches {
+		end := len(line)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		fields[line[match[2]:match[3]]] = strings.TrimSpace(line[match[1]:end])
+	}
+	return fields
+}
 
-		nodes[nodeName] = &NodeMetrics{}
+// ParseNodeDetails parses the output of scontrol show node -o, a line per
+// node. The result is sorted by node name.
+func ParseNodeDetails(input []byte) []NodeDetails {
+	var nodes []NodeDetails
+	for _, line := range SplitLines(input) {
+		fields := parseScontrolFields(strings.TrimSpace(line))
+		name, ok := fields["NodeName"]
+		if !ok {
+			continue
+		}
+		node := NodeDetails{name: name, partitions: parseList(fields["Partitions"])}
+		if load, err := strconv.ParseFloat(fields["CPULoad"], 64); err == nil {
+			node.cpuLoad, node.hasCPULoad = load, true
+		}
+		if free, err := strconv.ParseFloat(fields["FreeMem"], 64); err == nil {
+			node.freeMem, node.hasFreeMem = free, true
+		}
+		node.realMemory, _ = strconv.ParseFloat(fields["RealMemory"], 64)
+		// both are only listed when configured
+		node.memSpecLimit, _ = strconv.ParseFloat(fields["MemSpecLimit"], 64)
+		node.coreSpecCount, _ = strconv.ParseFloat(fields["CoreSpecCount"], 64)
+		// the watts are n/a or n/s without energy accounting
+		if watts, err := strconv.ParseFloat(fields["CurrentWatts"], 64); err == nil {
+			node.hasEnergy = true
+			node.currentWatts = watts
+			node.averageWatts, _ = strconv.ParseFloat(fields["AveWatts"], 64)
+			node.consumedJoules, _ = strconv.ParseFloat(fields["ConsumedJoules"], 64)
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+	return nodes
+}
 
-		nodes[nodeName].cpu = ParseCPUs(node[3])
-		memAlloc, _ := strconv.ParseFloat(node[1], 64)
-		memTotal, _ := strconv.ParseFloat(node[2], 64)
+// FeatureMetrics sums the CPUs and GPUs of the nodes which have a feature.
+type FeatureMetrics struct {
+	cpuAlloc float64
+	cpuIdle  float64
+	cpuTotal float64
+	gpuAlloc float64
+	gpuTotal float64
+}
 
-		if len(node) >= 6 && node[5] != "(null)" && len(node[5]) > 0 {
-			nodes[nodeName].gres = ParseGenericResources(node[5])
-			nodes[nodeName].gresUsed = ParseGenericResources(node[6])
+// ParseFeatureMetrics groups the nodes by the features they have in
+// slurm.conf, a node counts towards each of its features.
+func ParseFeatureMetrics(nodes []Node) map[string]*FeatureMetrics {
+	features := make(map[string]*FeatureMetrics)
+	for _, node := range nodes {
+		var gpuAlloc, gpuTotal float64
+		for _, gres := range node.gres {
+			if gres.gresType == "gpu" {
+				gpuTotal += gres.count
+			}
+		}
+		for _, gres := range node.gresUsed {
+			if gres.gresType == "gpu" {
+				gpuAlloc += gres.count
+			}
 		}
 
-		nodes[nodeName].memAlloc = memAlloc
-		nodes[nodeName].memTotal = memTotal
-		nodes[nodeName].nodeStatus = nodeStatus
+		for _, feature := range node.features {
+			fm, ok := features[feature]
+			if !ok {
+				fm = &FeatureMetrics{}
+				features[feature] = fm
+			}
+			fm.cpuAlloc += node.cpu.alloc
+			fm.cpuIdle += node.cpu.idle
+			fm.cpuTotal += node.cpu.total
+			fm.gpuAlloc += gpuAlloc
+			fm.gpuTotal += gpuTotal
+		}
 	}
-
-	return nodes
+	return features
 }
 
 type NodeCollector struct {
@@ -78,18 +153,55 @@ type NodeCollector struct {
 	memTotal *prometheus.Desc
 	gpuAlloc *prometheus.Desc
 	gpuTotal *prometheus.Desc
-	logger   log.Logger
+	// features and activeFeatures are info metrics, the others sum the
+	// nodes by feature
+	features        *prometheus.Desc
+	activeFeatures  *prometheus.Desc
+	featureCPUAlloc *prometheus.Desc
+	featureCPUIdle  *prometheus.Desc
+	featureCPUTotal *prometheus.Desc
+	featureGPUAlloc *prometheus.Desc
+	featureGPUIdle  *prometheus.Desc
+	featureGPUTotal *prometheus.Desc
+	// the details are only collected with the details option, as they
+	// take another call to slurmctld
+	details       bool
+	cpuLoad       *prometheus.Desc
+	memFree       *prometheus.Desc
+	memReal       *prometheus.Desc
+	memSpecLimit  *prometheus.Desc
+	coreSpecCount *prometheus.Desc
+	client        SlurmClient
+	logger        log.Logger
+}
+
+// nodeOptions are the options of the node collector in the configuration
+// file.
+type nodeOptions struct {
+	// Details adds the load and free memory of the nodes from scontrol
+	Details bool `yaml:"details"`
+}
+
+func defaultNodeOptions() interface{} {
+	return &nodeOptions{}
 }
 
 func init() {
 	registerCollector("node", defaultEnabled, NewNodeCollector)
+	registerCollectorOptions("node", defaultNodeOptions)
 }
 
 // NewNodeCollector creates a Prometheus collector to keep all our stats in
 // It returns a set of collections for consumption
-func NewNodeCollector(logger log.Logger) (Collector, error) {
+func NewNodeCollector(logger log.Logger, client SlurmClient) (Collector, error) {
+	options := defaultNodeOptions().(*nodeOptions)
+	if err := decodeOptions("node", options); err != nil {
+		return nil, err
+	}
 	return &NodeCollector{
+		client:   client,
 		logger:   logger,
+		details:  options.Details,
 		cpuAlloc: prometheus.NewDesc("slurm_node_cpu_alloc", "Allocated CPUs per node", []string{"node", "status"}, nil),
 		cpuIdle:  prometheus.NewDesc("slurm_node_cpu_idle", "Idle CPUs per node", []string{"node", "status"}, nil),
 		cpuOther: prometheus.NewDesc("slurm_node_cpu_other", "Other CPUs per node", []string{"node", "status"}, nil),
@@ -98,33 +210,80 @@ func NewNodeCollector(logger log.Logger) (Collector, error) {
 		memTotal: prometheus.NewDesc("slurm_node_mem_total", "Total memory per node", []string{"node", "status"}, nil),
 		gpuAlloc: prometheus.NewDesc("slurm_node_gpu_alloc", "Allocated GPUs per node", []string{"node", "status", "gputype"}, nil),
 		gpuTotal: prometheus.NewDesc("slurm_node_gpu_total", "Total GPUs per node", []string{"node", "status", "gputype"}, nil),
+
+		features:        prometheus.NewDesc("slurm_node_features_info", "Features of the node in slurm.conf", []string{"node", "feature"}, nil),
+		activeFeatures:  prometheus.NewDesc("slurm_node_active_features_info", "Features currently active on the node", []string{"node", "feature"}, nil),
+		featureCPUAlloc: prometheus.NewDesc("slurm_feature_cpu_alloc", "Allocated CPUs of the nodes with a feature", []string{"feature"}, nil),
+		featureCPUIdle:  prometheus.NewDesc("slurm_feature_cpu_idle", "Idle CPUs of the nodes with a feature", []string{"feature"}, nil),
+		featureCPUTotal: prometheus.NewDesc("slurm_feature_cpu_total", "Total CPUs of the nodes with a feature", []string{"feature"}, nil),
+		featureGPUAlloc: prometheus.NewDesc("slurm_feature_gpu_alloc", "Allocated GPUs of the nodes with a feature", []string{"feature"}, nil),
+		featureGPUIdle:  prometheus.NewDesc("slurm_feature_gpu_idle", "Idle GPUs of the nodes with a feature", []string{"feature"}, nil),
+		featureGPUTotal: prometheus.NewDesc("slurm_feature_gpu_total", "Total GPUs of the nodes with a feature", []string{"feature"}, nil),
+
+		cpuLoad:       prometheus.NewDesc("slurm_node_cpu_load", "Load average of the node", []string{"node"}, nil),
+		memFree:       prometheus.NewDesc("slurm_node_mem_free", "Free memory of the node, as reported by the OS", []string{"node"}, nil),
+		memReal:       prometheus.NewDesc("slurm_node_mem_real", "Memory of the node configured in Slurm", []string{"node"}, nil),
+		memSpecLimit:  prometheus.NewDesc("slurm_node_mem_spec_limit", "Memory of the node reserved for system use", []string{"node"}, nil),
+		coreSpecCount: prometheus.NewDesc("slurm_node_core_spec_count", "Cores of the node reserved for system use", []string{"node"}, nil),
 	}, nil
 }
 
-func (c *NodeCollector) Collect(ch chan<- prometheus.Metric) error {
-	out, err := RunCommand("sinfo", "-h", "-a", "-N", "-O", "NodeList: ,AllocMem: ,Memory: ,CPUsState: ,StateLong: ,Gres: ,Gresused:")
+func (c *NodeCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	nodes, err := c.client.Nodes(ctx)
 	if err != nil {
 		return err
 	}
 
-	nodes := ParseNodeMetrics(out)
-	for node := range nodes {
-		ch <- prometheus.MustNewConstMetric(c.cpuAlloc, prometheus.GaugeValue, nodes[node].cpu.alloc, node, nodes[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(c.cpuIdle, prometheus.GaugeValue, nodes[node].cpu.idle, node, nodes[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(c.cpuOther, prometheus.GaugeValue, nodes[node].cpu.other, node, nodes[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(c.cpuTotal, prometheus.GaugeValue, nodes[node].cpu.total, node, nodes[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(c.memAlloc, prometheus.GaugeValue, nodes[node].memAlloc, node, nodes[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(c.memTotal, prometheus.GaugeValue, nodes[node].memTotal, node, nodes[node].nodeStatus)
-		if nodes[node].gresUsed != nil && len(nodes[node].gresUsed) != 0 {
-			for _, tres := range nodes[node].gresUsed {
-				ch <- prometheus.MustNewConstMetric(c.gpuAlloc, prometheus.GaugeValue, tres.count, node, nodes[node].nodeStatus, tres.name)
-			}
+	for _, node := range nodes {
+		ch <- prometheus.MustNewConstMetric(c.cpuAlloc, prometheus.GaugeValue, node.cpu.alloc, node.name, node.nodeStatus)
+		ch <- prometheus.MustNewConstMetric(c.cpuIdle, prometheus.GaugeValue, node.cpu.idle, node.name, node.nodeStatus)
+		ch <- prometheus.MustNewConstMetric(c.cpuOther, prometheus.GaugeValue, node.cpu.other, node.name, node.nodeStatus)
+		ch <- prometheus.MustNewConstMetric(c.cpuTotal, prometheus.GaugeValue, node.cpu.total, node.name, node.nodeStatus)
+		ch <- prometheus.MustNewConstMetric(c.memAlloc, prometheus.GaugeValue, node.memAlloc, node.name, node.nodeStatus)
+		ch <- prometheus.MustNewConstMetric(c.memTotal, prometheus.GaugeValue, node.memTotal, node.name, node.nodeStatus)
+		for _, tres := range node.gresUsed {
+			ch <- prometheus.MustNewConstMetric(c.gpuAlloc, prometheus.GaugeValue, tres.count, node.name, node.nodeStatus, tres.name)
 		}
-		if nodes[node].gres != nil && len(nodes[node].gres) != 0 {
-			for _, tres := range nodes[node].gres {
-				ch <- prometheus.MustNewConstMetric(c.gpuTotal, prometheus.GaugeValue, tres.count, node, nodes[node].nodeStatus, tres.name)
-			}
+		for _, tres := range node.gres {
+			ch <- prometheus.MustNewConstMetric(c.gpuTotal, prometheus.GaugeValue, tres.count, node.name, node.nodeStatus, tres.name)
+		}
+		for _, feature := range node.features {
+			ch <- prometheus.MustNewConstMetric(c.features, prometheus.GaugeValue, 1, node.name, feature)
+		}
+		for _, feature := range node.activeFeatures {
+			ch <- prometheus.MustNewConstMetric(c.activeFeatures, prometheus.GaugeValue, 1, node.name, feature)
+		}
+	}
+
+	for feature, fm := range ParseFeatureMetrics(nodes) {
+		ch <- prometheus.MustNewConstMetric(c.featureCPUAlloc, prometheus.GaugeValue, fm.cpuAlloc, feature)
+		ch <- prometheus.MustNewConstMetric(c.featureCPUIdle, prometheus.GaugeValue, fm.cpuIdle, feature)
+		ch <- prometheus.MustNewConstMetric(c.featureCPUTotal, prometheus.GaugeValue, fm.cpuTotal, feature)
+		ch <- prometheus.MustNewConstMetric(c.featureGPUAlloc, prometheus.GaugeValue, fm.gpuAlloc, feature)
+		ch <- prometheus.MustNewConstMetric(c.featureGPUIdle, prometheus.GaugeValue, fm.gpuTotal-fm.gpuAlloc, feature)
+		ch <- prometheus.MustNewConstMetric(c.featureGPUTotal, prometheus.GaugeValue, fm.gpuTotal, feature)
+	}
+
+	if !c.details {
+		return nil
+	}
+	// the node metrics were already sent, so failing details do not fail
+	// the collector
+	details, err := c.client.NodeDetails(ctx)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "Unable to get the node details", "err", err)
+		return nil
+	}
+	for _, node := range details {
+		if node.hasCPULoad {
+			ch <- prometheus.MustNewConstMetric(c.cpuLoad, prometheus.GaugeValue, node.cpuLoad, node.name)
+		}
+		if node.hasFreeMem {
+			ch <- prometheus.MustNewConstMetric(c.memFree, prometheus.GaugeValue, node.freeMem, node.name)
 		}
+		ch <- prometheus.MustNewConstMetric(c.memReal, prometheus.GaugeValue, node.realMemory, node.name)
+		ch <- prometheus.MustNewConstMetric(c.memSpecLimit, prometheus.GaugeValue, node.memSpecLimit, node.name)
+		ch <- prometheus.MustNewConstMetric(c.coreSpecCount, prometheus.GaugeValue, node.coreSpecCount, node.name)
 	}
 
 	return nil
@@ -0,0 +1,118 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// energyCounter turns the joules a node consumed since slurmd started into
+// a counter which survives the restarts of slurmd.
+type energyCounter struct {
+	last  float64
+	total float64
+}
+
+// observe adds what a node consumed since the previous observation. A
+// value lower than the previous one means slurmd restarted and counts
+// from 0 again.
+func (c *energyCounter) observe(joules float64) {
+	if joules < c.last {
+		c.total += joules
+	} else {
+		c.total += joules - c.last
+	}
+	c.last = joules
+}
+
+type NodeEnergyCollector struct {
+	watts          *prometheus.Desc
+	averageWatts   *prometheus.Desc
+	joules         *prometheus.Desc
+	partitionWatts *prometheus.Desc
+	client         SlurmClient
+	logger         log.Logger
+
+	// mtx guards the counters, which every scrape moves
+	mtx      sync.Mutex
+	counters map[string]*energyCounter
+}
+
+func init() {
+	registerCollector("node_energy", defaultDisabled, NewNodeEnergyCollector)
+}
+
+func NewNodeEnergyCollector(logger log.Logger, client SlurmClient) (Collector, error) {
+	return &NodeEnergyCollector{
+		client:         client,
+		logger:         logger,
+		counters:       make(map[string]*energyCounter),
+		watts:          prometheus.NewDesc("slurm_node_power_watts", "Current power of the node, as gathered by AcctGatherEnergyType", []string{"node"}, nil),
+		averageWatts:   prometheus.NewDesc("slurm_node_power_average_watts", "Average power of the node since slurmd started", []string{"node"}, nil),
+		joules:         prometheus.NewDesc("slurm_node_energy_joules_total", "Energy consumed by the node since the exporter started", []string{"node"}, nil),
+		partitionWatts: prometheus.NewDesc("slurm_partition_power_watts", "Current power of the nodes of the partition", []string{"partition"}, nil),
+	}, nil
+}
+
+func (ec *NodeEnergyCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	ec.mtx.Lock()
+	defer ec.mtx.Unlock()
+
+	nodes, err := ec.client.NodeDetails(ctx)
+	if err != nil {
+		return err
+	}
+	ec.update(nodes)
+
+	partitions := make(map[string]float64)
+	for _, node := range nodes {
+		if !node.hasEnergy {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(ec.watts, prometheus.GaugeValue, node.currentWatts, node.name)
+		ch <- prometheus.MustNewConstMetric(ec.averageWatts, prometheus.GaugeValue, node.averageWatts, node.name)
+		ch <- prometheus.MustNewConstMetric(ec.joules, prometheus.CounterValue, ec.counters[node.name].total, node.name)
+		for _, partition := range node.partitions {
+			partitions[partition] += node.currentWatts
+		}
+	}
+	for partition, watts := range partitions {
+		ch <- prometheus.MustNewConstMetric(ec.partitionWatts, prometheus.GaugeValue, watts, partition)
+	}
+	return nil
+}
+
+// update moves the energy counters of the nodes with energy accounting.
+// The joules consumed before the first run are not counted, since there is
+// no telling when slurmd last restarted.
+func (ec *NodeEnergyCollector) update(nodes []NodeDetails) {
+	for _, node := range nodes {
+		if !node.hasEnergy {
+			continue
+		}
+		counter, ok := ec.counters[node.name]
+		if !ok {
+			ec.counters[node.name] = &energyCounter{last: node.consumedJoules}
+			continue
+		}
+		counter.observe(node.consumedJoules)
+	}
+}
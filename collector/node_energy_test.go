@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnergyCounter(t *testing.T) {
+	counter := &energyCounter{last: 1000}
+	counter.observe(1500)
+	assert.Equal(t, 500.0, counter.total)
+	// slurmd restarted and consumed 200 J since
+	counter.observe(200)
+	assert.Equal(t, 700.0, counter.total)
+	counter.observe(300)
+	assert.Equal(t, 800.0, counter.total)
+}
+
+func TestNodeEnergyCollector(t *testing.T) {
+	collector, err := NewNodeEnergyCollector(log.NewNopLogger(), fixtureClient{nodeDetails: "fixtures/scontrol/node.txt"})
+	require.NoError(t, err)
+	ec := collector.(*NodeEnergyCollector)
+
+	expected := `
+# HELP slurm_partition_power_watts Current power of the nodes of the partition
+# TYPE slurm_partition_power_watts gauge
+slurm_partition_power_watts{partition="debug"} 1540
+slurm_partition_power_watts{partition="gpu"} 14590
+`
+	c := &registryCollector{Collector: ec}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_partition_power_watts"))
+	assert.NoError(t, c.err)
+	// gpunode102 has no energy accounting
+	assert.Equal(t, 7, testutil.CollectAndCount(c, "slurm_node_power_watts"))
+
+	nodes := ParseNodeDetails(readFixture(t, "fixtures/scontrol/node.txt"))
+	for i := range nodes {
+		nodes[i].consumedJoules += 3600
+	}
+	// slurmd of gpunode01 restarted
+	nodes[0].consumedJoules = 1000
+	ec.update(nodes)
+	assert.Equal(t, 1000.0, ec.counters["gpunode01"].total)
+	assert.Equal(t, 3600.0, ec.counters["gpunode02"].total)
+	assert.NotContains(t, ec.counters, "gpunode102")
+}
@@ -0,0 +1,197 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sinfoReasonFormat lists the fields of the nodes read from sinfo -R, the
+// reason is last as it is free text.
+const sinfoReasonFormat = "%N|%T|%u|%H|%E"
+
+// NodeReason is why a node is down, drained or failing, as set by an
+// administrator or by Slurm itself.
+type NodeReason struct {
+	node   string
+	state  string
+	user   string
+	reason string
+	// since is a Unix timestamp, 0 if unknown
+	since int64
+}
+
+// ParseNodeReasons parses the output of sinfo -R formatted with
+// sinfoReasonFormat, with a record per node of the node lists.
+func ParseNodeReasons(input []byte) []NodeReason {
+	return parseNodeReasons(input, time.Local)
+}
+
+// parseNodeReasons reads the times the reasons were set in loc.
+func parseNodeReasons(input []byte, loc *time.Location) []NodeReason {
+	var reasons []NodeReason
+	seen := make(map[string]bool)
+	for _, line := range SplitLines(input) {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 5)
+		if len(parts) < 5 {
+			continue
+		}
+		since, _ := parseSlurmTime(parts[3], loc)
+		for _, node := range expandHostlist(parts[0]) {
+			if seen[node] {
+				continue
+			}
+			seen[node] = true
+			reasons = append(reasons, NodeReason{
+				node:   node,
+				state:  parts[1],
+				user:   parts[2],
+				reason: parts[4],
+				since:  since,
+			})
+		}
+	}
+	return reasons
+}
+
+// expandHostlist expands a Slurm host list, e.g. "node[01-03,07],login"
+// into node01, node02, node03, node07 and login. Ranges keep the zero
+// padding of their first bound.
+func expandHostlist(hostlist string) []string {
+	var hosts []string
+	for _, group := range splitHostlist(hostlist) {
+		hosts = append(hosts, expandHostGroup(group)...)
+	}
+	return hosts
+}
+
+// splitHostlist splits a host list on the commas outside of brackets.
+func splitHostlist(hostlist string) []string {
+	var groups []string
+	depth, start := 0, 0
+	for i, c := range hostlist {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				groups = append(groups, hostlist[start:i])
+				start = i + 1
+			}
+		}
+	}
+	groups = append(groups, hostlist[start:])
+
+	var nonEmpty []string
+	for _, group := range groups {
+		if group = strings.TrimSpace(group); group != "" {
+			nonEmpty = append(nonEmpty, group)
+		}
+	}
+	return nonEmpty
+}
+
+// expandHostGroup expands a single host name with any number of bracketed
+// ranges, e.g. "rack[1-2]-node[01-02]".
+func expandHostGroup(group string) []string {
+	open := strings.IndexByte(group, '[')
+	if open < 0 {
+		return []string{group}
+	}
+	end := strings.IndexByte(group[open:], ']')
+	if end < 0 {
+		return []string{group}
+	}
+	end += open
+	prefix, ranges, rest := group[:open], group[open+1:end], group[end+1:]
+
+	var numbers []string
+	for _, single := range strings.Split(ranges, ",") {
+		first, last, isRange := strings.Cut(single, "-")
+		if !isRange {
+			numbers = append(numbers, single)
+			continue
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil {
+			continue
+		}
+		for i := from; i <= to; i++ {
+			number := strconv.Itoa(i)
+			if padding := len(first) - len(number); padding > 0 {
+				number = strings.Repeat("0", padding) + number
+			}
+			numbers = append(numbers, number)
+		}
+	}
+
+	var hosts []string
+	suffixes := expandHostGroup(rest)
+	for _, number := range numbers {
+		for _, suffix := range suffixes {
+			hosts = append(hosts, prefix+number+suffix)
+		}
+	}
+	return hosts
+}
+
+type NodeReasonCollector struct {
+	info   *prometheus.Desc
+	since  *prometheus.Desc
+	client SlurmClient
+	logger log.Logger
+}
+
+func init() {
+	registerCollector("node_reason", defaultDisabled, NewNodeReasonCollector)
+}
+
+func NewNodeReasonCollector(logger log.Logger, client SlurmClient) (Collector, error) {
+	return &NodeReasonCollector{
+		client: client,
+		logger: logger,
+		info:   prometheus.NewDesc("slurm_node_reason_info", "Reason a node is down, drained or failing, and the user who set it", []string{"node", "state", "reason", "user"}, nil),
+		since:  prometheus.NewDesc("slurm_node_reason_since_timestamp_seconds", "Time the reason of a node was set, as a Unix timestamp", []string{"node"}, nil),
+	}, nil
+}
+
+func (nc *NodeReasonCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	reasons, err := nc.client.NodeReasons(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, reason := range reasons {
+		ch <- prometheus.MustNewConstMetric(nc.info, prometheus.GaugeValue, 1, reason.node, reason.state, reason.reason, reason.user)
+		if reason.since > 0 {
+			ch <- prometheus.MustNewConstMetric(nc.since, prometheus.GaugeValue, float64(reason.since), reason.node)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNodeReasons(t *testing.T) {
+	// the fixture was recorded in UTC
+	reasons := parseNodeReasons(readFixture(t, "fixtures/sinfo/reason.txt"), time.UTC)
+
+	require.Len(t, reasons, 10)
+	assert.Equal(t, NodeReason{
+		node:   "node07",
+		state:  "drained",
+		user:   "bob",
+		reason: "bad DIMM",
+		since:  time.Date(2024, 6, 16, 9, 30, 0, 0, time.UTC).Unix(),
+	}, reasons[3])
+	assert.Equal(t, "gpu-a2-b09", reasons[7].node)
+	assert.Equal(t, "GPU XID 79, reseat", reasons[7].reason)
+	assert.Equal(t, NodeReason{node: "login1", state: "fail", user: "root", reason: "power supply | fan"}, reasons[9])
+}
+
+func TestExpandHostlist(t *testing.T) {
+	assert.Equal(t, []string{"node01", "node02", "node03", "node07", "login"}, expandHostlist("node[01-03,07],login"))
+	assert.Equal(t, []string{"rack1-node8", "rack1-node9", "rack2-node8", "rack2-node9"}, expandHostlist("rack[1-2]-node[8-9]"))
+	assert.Equal(t, []string{"n098", "n099", "n100"}, expandHostlist("n[098-100]"))
+	assert.Equal(t, []string{"node1"}, expandHostlist("node1"))
+	assert.Empty(t, expandHostlist(""))
+}
+
+func TestNodeReasonCollector(t *testing.T) {
+	nc, err := NewNodeReasonCollector(log.NewNopLogger(), fixtureClient{nodeReasons: "fixtures/sinfo/reason.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_node_reason_info Reason a node is down, drained or failing, and the user who set it
+# TYPE slurm_node_reason_info gauge
+slurm_node_reason_info{node="gpu-a1-b08",reason="GPU XID 79, reseat",state="draining",user="alice"} 1
+slurm_node_reason_info{node="gpu-a1-b09",reason="GPU XID 79, reseat",state="draining",user="alice"} 1
+slurm_node_reason_info{node="gpu-a2-b08",reason="GPU XID 79, reseat",state="draining",user="alice"} 1
+slurm_node_reason_info{node="gpu-a2-b09",reason="GPU XID 79, reseat",state="draining",user="alice"} 1
+slurm_node_reason_info{node="login1",reason="power supply | fan",state="fail",user="root"} 1
+slurm_node_reason_info{node="node01",reason="bad DIMM",state="drained",user="bob"} 1
+slurm_node_reason_info{node="node02",reason="bad DIMM",state="drained",user="bob"} 1
+slurm_node_reason_info{node="node03",reason="bad DIMM",state="drained",user="bob"} 1
+slurm_node_reason_info{node="node07",reason="bad DIMM",state="drained",user="bob"} 1
+slurm_node_reason_info{node="node12",reason="Not responding",state="down",user="slurm"} 1
+`
+	c := &registryCollector{Collector: nc}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_node_reason_info"))
+	assert.NoError(t, c.err)
+	// login1 has no timestamp
+	assert.Equal(t, 19, testutil.CollectAndCount(c))
+}
@@ -3,18 +3,29 @@ package collector
 import (
 	"io"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNodeMetrics(t *testing.T) {
 	// Read the input data from a file
 	file, _ := os.Open("fixtures/sinfo/node.txt")
 	data, _ := io.ReadAll(file)
-	metrics := ParseNodeMetrics(data)
+	nodes := ParseNodes(data)
+	metrics := make(map[string]Node)
+	for _, node := range nodes {
+		metrics[node.name] = node
+	}
 
+	assert.Len(t, nodes, 8)
 	assert.Contains(t, metrics, "gpunode05")
+	assert.Equal(t, []string{"gpu", "debug"}, metrics["gpunode05"].partitions)
+	assert.Equal(t, "mixed", metrics["gpunode05"].nodeStatus)
 	assert.Equal(t, float64(0), metrics["gpunode05"].memAlloc)
 	assert.Equal(t, float64(515500), metrics["gpunode05"].memTotal)
 	assert.Equal(t, float64(60), metrics["gpunode05"].cpu.alloc)
@@ -29,4 +40,112 @@ func TestNodeMetrics(t *testing.T) {
 	assert.Equal(t, float64(4), metrics["gpunode05"].gresUsed[0].count)
 	assert.Equal(t, "a100m80", metrics["gpunode05"].gresUsed[1].name)
 	assert.Equal(t, float64(4), metrics["gpunode05"].gresUsed[1].count)
+	assert.Equal(t, []string{"avx512", "ib_hdr", "a100"}, metrics["gpunode05"].features)
+	assert.Equal(t, []string{"avx512", "ib_hdr", "a100"}, metrics["gpunode05"].activeFeatures)
+	assert.Equal(t, []string{"avx512", "v100"}, metrics["gpunode102"].features)
+	assert.Empty(t, metrics["gpunode102"].activeFeatures)
+}
+
+func TestParseFeatureMetrics(t *testing.T) {
+	features := ParseFeatureMetrics(ParseNodes(readFixture(t, "fixtures/sinfo/node.txt")))
+
+	assert.Len(t, features, 6)
+	assert.Equal(t, &FeatureMetrics{cpuAlloc: 16, cpuIdle: 24, cpuTotal: 136, gpuAlloc: 4, gpuTotal: 20}, features["v100"])
+	assert.Equal(t, &FeatureMetrics{cpuAlloc: 32, cpuIdle: 0, cpuTotal: 256, gpuAlloc: 2, gpuTotal: 8}, features["amd"])
+	assert.Equal(t, 21.0+32+64+48+60+16, features["avx512"].cpuAlloc)
+}
+
+func TestNodeCollectorFeatures(t *testing.T) {
+	nc, err := NewNodeCollector(log.NewNopLogger(), fixtureClient{nodes: "fixtures/sinfo/node.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_feature_gpu_idle Idle GPUs of the nodes with a feature
+# TYPE slurm_feature_gpu_idle gauge
+slurm_feature_gpu_idle{feature="a100"} 16
+slurm_feature_gpu_idle{feature="a6000"} 0
+slurm_feature_gpu_idle{feature="amd"} 6
+slurm_feature_gpu_idle{feature="avx512"} 26
+slurm_feature_gpu_idle{feature="ib_hdr"} 16
+slurm_feature_gpu_idle{feature="v100"} 16
+`
+	c := &registryCollector{Collector: nc}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_feature_gpu_idle"))
+	assert.NoError(t, c.err)
+	assert.Equal(t, 22, testutil.CollectAndCount(c, "slurm_node_features_info"))
+	assert.Equal(t, 20, testutil.CollectAndCount(c, "slurm_node_active_features_info"))
+}
+
+func TestParseNodeDetails(t *testing.T) {
+	nodes := ParseNodeDetails(readFixture(t, "fixtures/scontrol/node.txt"))
+
+	require.Len(t, nodes, 8)
+	assert.Equal(t, NodeDetails{
+		name:           "gpunode02",
+		partitions:     []string{"gpu"},
+		cpuLoad:        31.87,
+		hasCPULoad:     true,
+		freeMem:        702311,
+		hasFreeMem:     true,
+		realMemory:     1031000,
+		memSpecLimit:   8192,
+		coreSpecCount:  2,
+		hasEnergy:      true,
+		currentWatts:   2410,
+		averageWatts:   2300,
+		consumedJoules: 1203456789,
+	}, nodes[1])
+	// gpunode102 does not respond
+	assert.Equal(t, NodeDetails{name: "gpunode102", partitions: []string{"gpu"}, realMemory: 1536000}, nodes[6])
+
+	fromJSON, err := ParseNodeDetailsJSON(readFixture(t, "fixtures/scontrol/slurm-23.11.4/nodes.json"))
+	require.NoError(t, err)
+	assert.Equal(t, nodes, fromJSON)
+}
+
+func TestParseScontrolFields(t *testing.T) {
+	fields := parseScontrolFields("NodeName=node1 OS=Linux 5.14.0 #1 SMP CfgTRES=cpu=8,mem=1G Reason=bad DIMM [root@2024-06-19T07:45:12]")
+	assert.Equal(t, map[string]string{
+		"NodeName": "node1",
+		"OS":       "Linux 5.14.0 #1 SMP",
+		"CfgTRES":  "cpu=8,mem=1G",
+		"Reason":   "bad DIMM [root@2024-06-19T07:45:12]",
+	}, fields)
+}
+
+func TestNodeCollectorDetails(t *testing.T) {
+	client := fixtureClient{nodes: "fixtures/sinfo/node.txt", nodeDetails: "fixtures/scontrol/node.txt"}
+	nc, err := NewNodeCollector(log.NewNopLogger(), client)
+	require.NoError(t, err)
+	c := &registryCollector{Collector: nc}
+	assert.Equal(t, 0, testutil.CollectAndCount(c, "slurm_node_cpu_load"))
+
+	require.NoError(t, loadTestConfig(t, "collectors:\n  node:\n    options:\n      details: true\n"))
+	nc, err = NewNodeCollector(log.NewNopLogger(), client)
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_node_cpu_load Load average of the node
+# TYPE slurm_node_cpu_load gauge
+slurm_node_cpu_load{node="gpunode01"} 20.51
+slurm_node_cpu_load{node="gpunode02"} 31.87
+slurm_node_cpu_load{node="gpunode03"} 64.02
+slurm_node_cpu_load{node="gpunode04"} 52.3
+slurm_node_cpu_load{node="gpunode05"} 12
+slurm_node_cpu_load{node="gpunode101"} 30.15
+slurm_node_cpu_load{node="gpunode103"} 15.99
+`
+	c = &registryCollector{Collector: nc}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_node_cpu_load"))
+	assert.NoError(t, c.err)
+	assert.Equal(t, 7, testutil.CollectAndCount(c, "slurm_node_mem_free"))
+	assert.Equal(t, 8, testutil.CollectAndCount(c, "slurm_node_mem_spec_limit"))
+
+	// the node metrics are kept when the details fail
+	nc, err = NewNodeCollector(log.NewNopLogger(), fixtureClient{nodes: "fixtures/sinfo/node.txt"})
+	require.NoError(t, err)
+	c = &registryCollector{Collector: nc}
+	assert.Equal(t, 0, testutil.CollectAndCount(c, "slurm_node_cpu_load"))
+	assert.NoError(t, c.err)
+	assert.Equal(t, 8, testutil.CollectAndCount(c, "slurm_node_cpu_total"))
 }
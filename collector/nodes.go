@@ -18,10 +18,8 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
+	"context"
 	"regexp"
-	"sort"
-	"strconv"
-	"strings"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -39,9 +37,13 @@ type NodesMetrics struct {
 	mix   float64
 	resv  float64
 	plnd  float64
+	// states counts every node once, by base state, and flags the nodes
+	// with each flag
+	states map[string]float64
+	flags  map[string]float64
 }
 
-func ParseNodesMetrics(input []byte) *NodesMetrics {
+func ParseNodesMetrics(nodes []Node) *NodesMetrics {
 	var (
 		alloc = regexp.MustCompile(`^alloc`)
 		comp  = regexp.MustCompile(`^comp`)
@@ -56,43 +58,37 @@ func ParseNodesMetrics(input []byte) *NodesMetrics {
 		plnd  = regexp.MustCompile(`^plan`)
 	)
 
-	lines := SplitLines(input)
-	// Sort and remove all the duplicates from the 'sinfo' output
-	sort.Strings(lines)
-	linesUniq := RemoveDuplicates(lines)
-
-	var nm NodesMetrics
-	for _, line := range linesUniq {
-		if strings.Contains(line, ",") {
-			parts := strings.Split(line, ",")
-
-			count, _ := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
-			state := parts[1]
-
-			switch {
-			case alloc.MatchString(state) == true:
-				nm.alloc += count
-			case comp.MatchString(state) == true:
-				nm.comp += count
-			case down.MatchString(state) == true:
-				nm.down += count
-			case drain.MatchString(state) == true:
-				nm.drain += count
-			case fail.MatchString(state) == true:
-				nm.fail += count
-			case err.MatchString(state) == true:
-				nm.err += count
-			case idle.MatchString(state) == true:
-				nm.idle += count
-			case maint.MatchString(state) == true:
-				nm.maint += count
-			case mix.MatchString(state) == true:
-				nm.mix += count
-			case resv.MatchString(state) == true:
-				nm.resv += count
-			case plnd.MatchString(state) == true:
-				nm.plnd += count
-			}
+	nm := NodesMetrics{states: make(map[string]float64), flags: make(map[string]float64)}
+	for _, node := range nodes {
+		nm.states[node.state]++
+		for _, flag := range node.flags {
+			nm.flags[flag]++
+		}
+
+		state := node.nodeStatus
+		switch {
+		case alloc.MatchString(state) == true:
+			nm.alloc++
+		case comp.MatchString(state) == true:
+			nm.comp++
+		case down.MatchString(state) == true:
+			nm.down++
+		case drain.MatchString(state) == true:
+			nm.drain++
+		case fail.MatchString(state) == true:
+			nm.fail++
+		case err.MatchString(state) == true:
+			nm.err++
+		case idle.MatchString(state) == true:
+			nm.idle++
+		case maint.MatchString(state) == true:
+			nm.maint++
+		case mix.MatchString(state) == true:
+			nm.mix++
+		case resv.MatchString(state) == true:
+			nm.resv++
+		case plnd.MatchString(state) == true:
+			nm.plnd++
 		}
 	}
 	return &nm
@@ -110,6 +106,9 @@ type NodesCollector struct {
 	mix    *prometheus.Desc
 	resv   *prometheus.Desc
 	plnd   *prometheus.Desc
+	nodes  *prometheus.Desc
+	flags  *prometheus.Desc
+	client SlurmClient
 	logger log.Logger
 }
 
@@ -117,8 +116,9 @@ func init() {
 	registerCollector("nodes", defaultEnabled, NewNodesCollector)
 }
 
-func NewNodesCollector(logger log.Logger) (Collector, error) {
+func NewNodesCollector(logger log.Logger, client SlurmClient) (Collector, error) {
 	return &NodesCollector{
+		client: client,
 		logger: logger,
 		alloc:  prometheus.NewDesc("slurm_nodes_alloc", "Allocated nodes", nil, nil),
 		comp:   prometheus.NewDesc("slurm_nodes_comp", "Completing nodes", nil, nil),
@@ -131,16 +131,18 @@ func NewNodesCollector(logger log.Logger) (Collector, error) {
 		mix:    prometheus.NewDesc("slurm_nodes_mix", "Mix nodes", nil, nil),
 		resv:   prometheus.NewDesc("slurm_nodes_resv", "Reserved nodes", nil, nil),
 		plnd:   prometheus.NewDesc("slurm_nodes_plnd", "Planned nodes", nil, nil),
+		nodes:  prometheus.NewDesc("slurm_nodes", "Nodes by base state, each node is counted once", []string{"state"}, nil),
+		flags:  prometheus.NewDesc("slurm_node_flags", "Nodes with a flag of the node state", []string{"flag"}, nil),
 	}, nil
 }
 
-func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) error {
-	out, err := RunCommand("sinfo", "-h", "-a", "-o %D,%T")
+func (nc *NodesCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	nodes, err := nc.client.Nodes(ctx)
 	if err != nil {
 		return err
 	}
 
-	nm := ParseNodesMetrics(out)
+	nm := ParseNodesMetrics(nodes)
 	ch <- prometheus.MustNewConstMetric(nc.alloc, prometheus.GaugeValue, nm.alloc)
 	ch <- prometheus.MustNewConstMetric(nc.comp, prometheus.GaugeValue, nm.comp)
 	ch <- prometheus.MustNewConstMetric(nc.down, prometheus.GaugeValue, nm.down)
@@ -152,6 +154,12 @@ func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) error {
 	ch <- prometheus.MustNewConstMetric(nc.mix, prometheus.GaugeValue, nm.mix)
 	ch <- prometheus.MustNewConstMetric(nc.resv, prometheus.GaugeValue, nm.resv)
 	ch <- prometheus.MustNewConstMetric(nc.plnd, prometheus.GaugeValue, nm.plnd)
+	for state, count := range nm.states {
+		ch <- prometheus.MustNewConstMetric(nc.nodes, prometheus.GaugeValue, count, state)
+	}
+	for flag, count := range nm.flags {
+		ch <- prometheus.MustNewConstMetric(nc.flags, prometheus.GaugeValue, count, flag)
+	}
 
 	return nil
 }
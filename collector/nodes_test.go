@@ -1,17 +1,22 @@
 package collector
 
 import (
-	"github.com/stretchr/testify/assert"
 	"io"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNodesMetrics(t *testing.T) {
 	// Read the input data from a file
 	file, _ := os.Open("fixtures/sinfo/nodes.txt")
 	data, _ := io.ReadAll(file)
-	nodes := ParseNodesMetrics(data)
+	nodes := ParseNodesMetrics(ParseNodes(data))
 
 	assert.Equal(t, 1.0, nodes.alloc)
 	assert.Equal(t, 0.0, nodes.comp)
@@ -25,3 +30,74 @@ func TestNodesMetrics(t *testing.T) {
 	assert.Equal(t, 0.0, nodes.resv)
 	assert.Equal(t, 0.0, nodes.plnd)
 }
+
+func TestNodesMetricsStates(t *testing.T) {
+	nodes := ParseNodesMetrics(ParseNodes(readFixture(t, "fixtures/sinfo/states.txt")))
+
+	assert.Equal(t, map[string]float64{"idle": 10, "mixed": 2, "down": 1, "allocated": 1}, nodes.states)
+	assert.Equal(t, map[string]float64{
+		"drain":            4,
+		"completing":       1,
+		"not_responding":   2,
+		"powered_down":     2,
+		"cloud":            1,
+		"powering_up":      1,
+		"reserved":         2,
+		"reboot_requested": 1,
+		"maint":            1,
+		"fail":             1,
+	}, nodes.flags)
+}
+
+func TestParseNodeState(t *testing.T) {
+	state, flags := parseNodeState([]string{"IDLE", "MAINTENANCE", "DRAIN", "DYNAMIC_NORM", "NOT_RESPONDING"})
+	assert.Equal(t, "idle", state)
+	assert.Equal(t, []string{"drain", "maint", "not_responding"}, flags)
+
+	state, flags = parseNodeState([]string{"mixed"})
+	assert.Equal(t, "mixed", state)
+	assert.Empty(t, flags)
+}
+
+func TestParseNodeStateLong(t *testing.T) {
+	state, flags := parseNodeStateLong("drained*", CPUs{other: 64, total: 64})
+	assert.Equal(t, "idle", state)
+	assert.Equal(t, []string{"drain", "not_responding"}, flags)
+
+	state, flags = parseNodeStateLong("draining", CPUs{alloc: 32, other: 32, total: 64})
+	assert.Equal(t, "mixed", state)
+	assert.Equal(t, []string{"drain"}, flags)
+
+	state, flags = parseNodeStateLong("down*", CPUs{other: 64, total: 64})
+	assert.Equal(t, "down", state)
+	assert.Equal(t, []string{"not_responding"}, flags)
+
+	// sinfo before 21.08 prints no StateComplete
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(readFixture(t, "fixtures/sinfo/states.txt"))), "\n") {
+		lines = append(lines, line[:strings.LastIndex(line, "|")])
+	}
+	nodes := ParseNodesMetrics(ParseNodes([]byte(strings.Join(lines, "\n"))))
+	assert.Equal(t, map[string]float64{"idle": 10, "mixed": 2, "down": 1, "allocated": 1}, nodes.states)
+	assert.Equal(t, 4.0, nodes.flags["drain"])
+}
+
+func TestNodesCollector(t *testing.T) {
+	nc, err := NewNodesCollector(log.NewNopLogger(), fixtureClient{nodes: "fixtures/sinfo/nodes.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_node_flags Nodes with a flag of the node state
+# TYPE slurm_node_flags gauge
+slurm_node_flags{flag="drain"} 7
+slurm_node_flags{flag="not_responding"} 2
+# HELP slurm_nodes Nodes by base state, each node is counted once
+# TYPE slurm_nodes gauge
+slurm_nodes{state="allocated"} 1
+slurm_nodes{state="idle"} 16
+slurm_nodes{state="mixed"} 20
+`
+	c := &registryCollector{Collector: nc}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_nodes", "slurm_node_flags"))
+	assert.NoError(t, c.err)
+}
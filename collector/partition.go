@@ -18,7 +18,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
-	"strings"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -30,38 +30,31 @@ type PartitionMetrics struct {
 	jobsRunning float64
 }
 
-func ParsePartitionMetrics(input []byte, runningOutput []byte, pendingOutput []byte) map[string]*PartitionMetrics {
+func ParsePartitionMetrics(nodes []Node, jobs []Job) map[string]*PartitionMetrics {
 	partitions := make(map[string]*PartitionMetrics)
 
-	for _, line := range SplitLines(input) {
-		if strings.Contains(line, ",") {
-			parts := strings.Split(line, ",")
-
-			// name of a partition
-			partition := parts[0]
+	// sum up the CPUs of all nodes in a partition
+	for _, node := range nodes {
+		for _, partition := range node.partitions {
 			_, key := partitions[partition]
 			if !key {
 				partitions[partition] = &PartitionMetrics{}
 			}
-			partitions[partition].cpu = ParseCPUs(parts[1])
+			partitions[partition].cpu.Add(node.cpu)
 		}
 	}
 
-	// get list of pending jobs by partition name
-	for _, partition := range SplitLines(pendingOutput) {
-		// accumulate the number of pending jobs
-		_, key := partitions[partition]
-		if key {
-			partitions[partition].jobsPending += 1
+	// accumulate the number of pending and running jobs
+	for _, job := range jobs {
+		_, key := partitions[job.partition]
+		if !key {
+			continue
 		}
-	}
-
-	// get list of running jobs by partition name
-	for _, partition := range SplitLines(runningOutput) {
-		// accumulate the number of running jobs
-		_, key := partitions[partition]
-		if key {
-			partitions[partition].jobsRunning += 1
+		switch job.state {
+		case "PENDING":
+			partitions[job.partition].jobsPending += job.count()
+		case "RUNNING":
+			partitions[job.partition].jobsRunning += job.count()
 		}
 	}
 
@@ -75,6 +68,7 @@ type PartitionCollector struct {
 	pending   *prometheus.Desc
 	running   *prometheus.Desc
 	total     *prometheus.Desc
+	client    SlurmClient
 	logger    log.Logger
 }
 
@@ -82,8 +76,9 @@ func init() {
 	registerCollector("partition", defaultEnabled, NewPartitionCollector)
 }
 
-func NewPartitionCollector(logger log.Logger) (Collector, error) {
+func NewPartitionCollector(logger log.Logger, client SlurmClient) (Collector, error) {
 	return &PartitionCollector{
+		client:    client,
 		logger:    logger,
 		allocated: prometheus.NewDesc("slurm_partition_cpus_allocated", "Allocated CPUs for partition", []string{"partition"}, nil),
 		idle:      prometheus.NewDesc("slurm_partition_cpus_idle", "Idle CPUs for partition", []string{"partition"}, nil),
@@ -94,21 +89,17 @@ func NewPartitionCollector(logger log.Logger) (Collector, error) {
 	}, nil
 }
 
-func (pc *PartitionCollector) Collect(ch chan<- prometheus.Metric) error {
-	sinfoOutput, err := RunCommand("sinfo", "-h", "-o%R,%C")
-	if err != nil {
-		return err
-	}
-	squeueRunningOutput, err := RunCommand("squeue", "-a", "-r", "-h", "-o%P", "--states=RUNNING")
+func (pc *PartitionCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	nodes, err := pc.client.Nodes(ctx)
 	if err != nil {
 		return err
 	}
-	squeuePendingOutput, err := RunCommand("squeue", "-a", "-r", "-h", "-o%P", "--states=PENDING")
+	jobs, err := pc.client.Jobs(ctx)
 	if err != nil {
 		return err
 	}
 
-	pm := ParsePartitionMetrics(sinfoOutput, squeueRunningOutput, squeuePendingOutput)
+	pm := ParsePartitionMetrics(nodes, jobs)
 	for p := range pm {
 		if pm[p].cpu.alloc > 0 {
 			ch <- prometheus.MustNewConstMetric(pc.allocated, prometheus.GaugeValue, pm[p].cpu.alloc, p)
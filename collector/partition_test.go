@@ -10,12 +10,10 @@ import (
 func TestParsePartitionsMetrics(t *testing.T) {
 	// Read the input data from a file
 	sinfoFile, _ := os.Open("fixtures/sinfo/partition.txt")
-	runningFile, _ := os.Open("fixtures/squeue/partition_running.txt")
-	pendingFile, _ := os.Open("fixtures/squeue/partition_pending.txt")
+	squeueFile, _ := os.Open("fixtures/squeue/partition.txt")
 	sinfoData, _ := io.ReadAll(sinfoFile)
-	runningData, _ := io.ReadAll(runningFile)
-	pendingData, _ := io.ReadAll(pendingFile)
-	partitionMetrics := ParsePartitionMetrics(sinfoData, runningData, pendingData)
+	squeueData, _ := io.ReadAll(squeueFile)
+	partitionMetrics := ParsePartitionMetrics(ParseNodes(sinfoData), ParseJobs(squeueData))
 
 	assert.Equal(t, 273.0, partitionMetrics["ampere"].cpu.alloc, "Miscount of allocated CPUs")
 	assert.Equal(t, 279.0, partitionMetrics["ampere"].cpu.idle, "Miscount of idle CPUs")
@@ -0,0 +1,130 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errNotRefreshed = errors.New("collector has not been refreshed yet")
+
+// pollingCollector refreshes a collector on its own interval in the
+// background, scrapes are served from the metrics of the latest run.
+type pollingCollector struct {
+	key       collectorKey
+	collector Collector
+	interval  time.Duration
+	logger    log.Logger
+	done      chan struct{}
+
+	mtx      sync.RWMutex
+	metrics  []prometheus.Metric
+	updated  time.Time
+	duration time.Duration
+	err      error
+}
+
+func newPollingCollector(key collectorKey, c Collector, interval time.Duration, logger log.Logger) *pollingCollector {
+	p := &pollingCollector{
+		key:       key,
+		collector: c,
+		interval:  interval,
+		logger:    logger,
+		err:       errNotRefreshed,
+		done:      make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+func (p *pollingCollector) loop() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.refresh()
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// stop ends the background refresh, a refresh in progress still completes.
+func (p *pollingCollector) stop() {
+	close(p.done)
+}
+
+// refresh runs the collector once. The cached metrics are only replaced
+// after a successful run, so a failing Slurm keeps serving the last data.
+func (p *pollingCollector) refresh() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = withSnapshot(ctx, newSnapshot(ctx))
+
+	ch := make(chan prometheus.Metric)
+	collected := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		collected <- metrics
+	}()
+
+	duration, err := run(ctx, p.key, p.collector, ch, p.logger)
+	close(ch)
+	metrics := <-collected
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.duration = duration
+	p.err = err
+	if err == nil {
+		p.metrics = metrics
+		p.updated = time.Now()
+	}
+}
+
+// serve sends the cached metrics together with the status of the last run.
+func (p *pollingCollector) serve(ch chan<- prometheus.Metric) {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	for _, metric := range p.metrics {
+		ch <- metric
+	}
+	sendStatus(ch, p.key, p.duration, p.err)
+	if !p.updated.IsZero() {
+		ch <- prometheus.MustNewConstMetric(scrapeCacheAgeDesc, prometheus.GaugeValue, time.Since(p.updated).Seconds(), p.key.name)
+	}
+}
+
+// Collect implements the Collector interface by serving the cached metrics.
+func (p *pollingCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	for _, metric := range p.metrics {
+		ch <- metric
+	}
+	return p.err
+}
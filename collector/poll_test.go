@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+var testDesc = prometheus.NewDesc("slurm_test", "Test metric", nil, nil)
+
+type testCollector struct {
+	calls int32
+	fail  atomic.Bool
+}
+
+func (c *testCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	calls := atomic.AddInt32(&c.calls, 1)
+	if c.fail.Load() {
+		return errors.New("slurmctld is down")
+	}
+	ch <- prometheus.MustNewConstMetric(testDesc, prometheus.GaugeValue, float64(calls))
+	return nil
+}
+
+func servedMetrics(p *pollingCollector) map[string]int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		p.serve(ch)
+		close(ch)
+	}()
+	names := make(map[string]int)
+	for metric := range ch {
+		names[metric.Desc().String()]++
+	}
+	return names
+}
+
+func TestPollingCollector(t *testing.T) {
+	c := &testCollector{}
+	p := &pollingCollector{key: collectorKey{name: "test"}, collector: c, interval: time.Hour, logger: log.NewNopLogger(), err: errNotRefreshed}
+
+	// Nothing is served before the first refresh.
+	served := servedMetrics(p)
+	assert.Equal(t, 0, served[testDesc.String()])
+	assert.Equal(t, 0, served[scrapeCacheAgeDesc.String()])
+
+	p.refresh()
+	served = servedMetrics(p)
+	assert.Equal(t, 1, served[testDesc.String()])
+	assert.Equal(t, 1, served[scrapeLastSuccessDesc.String()])
+	assert.Equal(t, 1, served[scrapeCacheAgeDesc.String()])
+	assert.NoError(t, p.err)
+
+	// A failed refresh keeps the previous metrics.
+	c.fail.Store(true)
+	p.refresh()
+	served = servedMetrics(p)
+	assert.Equal(t, 1, served[testDesc.String()])
+	assert.Error(t, p.err)
+	assert.Equal(t, int32(2), c.calls)
+}
@@ -18,12 +18,17 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
+	"context"
+	"fmt"
 	"strings"
 
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+var queueDimensionsFlag = kingpin.Flag("collector.queue.dimensions", "Comma separated labels of slurm_queue_jobs besides state, among partition, qos and account. The dimensions option of the configuration file overrides it.").Default("partition,qos").String()
+
 type QueueMetrics struct {
 	pending     float64
 	pendingDep  float64
@@ -38,47 +43,152 @@ type QueueMetrics struct {
 	preempted   float64
 	nodeFail    float64
 	outOfMemory float64
+	// pendingReasons breaks the pending jobs down by reason and partition
+	pendingReasons map[pendingReasonKey]*pendingReasonMetrics
+	// arrayJobs counts the array jobs with pending or running tasks
+	arrayJobs         float64
+	arrayTasksPending float64
+	arrayTasksRunning float64
+	// heterogeneous counts the heterogeneous jobs by state, and
+	// hetComponents their components
+	heterogeneous map[string]float64
+	hetComponents map[string]float64
+	// jobs counts the jobs of every state like the fields above, broken
+	// down by partition, QoS and account
+	jobs map[queueJobKey]float64
+}
+
+type queueJobKey struct {
+	state, partition, qos, account string
+}
+
+// queueDimensions are the labels slurm_queue_jobs can be broken down by,
+// besides the state.
+var queueDimensions = []string{"partition", "qos", "account"}
+
+// isQueueDimension reports whether slurm_queue_jobs can be broken down by a
+// label.
+func isQueueDimension(dimension string) bool {
+	for _, known := range queueDimensions {
+		if dimension == known {
+			return true
+		}
+	}
+	return false
+}
+
+// project keeps the labels of a key which are in dimensions.
+func (k queueJobKey) project(dimensions []string) (queueJobKey, []string) {
+	projected := queueJobKey{state: k.state}
+	values := []string{k.state}
+	for _, dimension := range dimensions {
+		switch dimension {
+		case "partition":
+			projected.partition = k.partition
+			values = append(values, k.partition)
+		case "qos":
+			projected.qos = k.qos
+			values = append(values, k.qos)
+		case "account":
+			projected.account = k.account
+			values = append(values, k.account)
+		}
+	}
+	return projected, values
 }
 
-func ParseQueueMetrics(input []byte) *QueueMetrics {
-	var qm QueueMetrics
-
-	for _, line := range SplitLines(input) {
-		if strings.Contains(line, ",") {
-			parts := strings.Split(line, ",")
-
-			state := parts[1]
-			switch state {
-			case "PENDING":
-				qm.pending++
-				if len(parts) > 2 && parts[2] == "Dependency" {
-					qm.pendingDep++
-				}
-			case "RUNNING":
-				qm.running++
-			case "SUSPENDED":
-				qm.suspended++
-			case "CANCELLED":
-				qm.cancelled++
-			case "COMPLETING":
-				qm.completing++
-			case "COMPLETED":
-				qm.completed++
-			case "CONFIGURING":
-				qm.configuring++
-			case "FAILED":
-				qm.failed++
-			case "TIMEOUT":
-				qm.timeout++
-			case "PREEMPTED":
-				qm.preempted++
-			case "NODE_FAIL":
-				qm.nodeFail++
-			case "OUT_OF_MEMORY":
-				qm.outOfMemory++
+type pendingReasonKey struct {
+	reason, partition string
+}
+
+// pendingReasonMetrics sums the pending jobs of a reason and what they
+// requested.
+type pendingReasonMetrics struct {
+	jobs, cpus, gpus float64
+}
+
+// pendingReason returns the reason a job is pending for, without the
+// details Slurm adds to some of them, e.g. the nodes in
+// "ReqNodeNotAvail, UnavailableNodes:node[01-02]".
+func pendingReason(reason string) string {
+	reason, _, _ = strings.Cut(reason, ",")
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return "None"
+	}
+	return reason
+}
+
+func ParseQueueMetrics(jobs []Job) *QueueMetrics {
+	qm := QueueMetrics{
+		pendingReasons: make(map[pendingReasonKey]*pendingReasonMetrics),
+		heterogeneous:  make(map[string]float64),
+		hetComponents:  make(map[string]float64),
+		jobs:           make(map[queueJobKey]float64),
+	}
+	arrays := make(map[string]bool)
+
+	for _, job := range jobs {
+		if job.arrayJobID != "" && (job.state == "PENDING" || job.state == "RUNNING") {
+			arrays[job.arrayJobID] = true
+			if job.state == "PENDING" {
+				qm.arrayTasksPending += job.tasks()
+			} else {
+				qm.arrayTasksRunning++
+			}
+		}
+
+		if job.hetJobID != "" {
+			qm.heterogeneous[job.state] += job.count()
+			qm.hetComponents[job.state]++
+		}
+
+		// the pending tasks of an array count as one job each, the
+		// components of a heterogeneous job once for all of them
+		count := job.count()
+		if count > 0 {
+			qm.jobs[queueJobKey{state: job.state, partition: job.partition, qos: job.qos, account: job.account}] += count
+		}
+		switch job.state {
+		case "PENDING":
+			qm.pending += count
+			if job.reason == "Dependency" {
+				qm.pendingDep += count
+			}
+			key := pendingReasonKey{reason: pendingReason(job.reason), partition: job.partition}
+			reason, ok := qm.pendingReasons[key]
+			if !ok {
+				reason = &pendingReasonMetrics{}
+				qm.pendingReasons[key] = reason
 			}
+			reason.jobs += count
+			reason.cpus += job.cpus * job.tasks()
+			reason.gpus += job.gpus * job.tasks()
+		case "RUNNING":
+			qm.running += count
+		case "SUSPENDED":
+			qm.suspended += count
+		case "CANCELLED":
+			qm.cancelled += count
+		case "COMPLETING":
+			qm.completing += count
+		case "COMPLETED":
+			qm.completed += count
+		case "CONFIGURING":
+			qm.configuring += count
+		case "FAILED":
+			qm.failed += count
+		case "TIMEOUT":
+			qm.timeout += count
+		case "PREEMPTED":
+			qm.preempted += count
+		case "NODE_FAIL":
+			qm.nodeFail += count
+		case "OUT_OF_MEMORY":
+			qm.outOfMemory += count
 		}
 	}
+	qm.arrayJobs = float64(len(arrays))
 	return &qm
 }
 
@@ -96,39 +206,109 @@ type QueueCollector struct {
 	preempted   *prometheus.Desc
 	nodeFail    *prometheus.Desc
 	outOfMemory *prometheus.Desc
-	logger      log.Logger
+	// arrayTasksPending and arrayTasksRunning are also part of pending and
+	// running
+	arrayJobs         *prometheus.Desc
+	arrayTasksPending *prometheus.Desc
+	arrayTasksRunning *prometheus.Desc
+	heterogeneous     *prometheus.Desc
+	hetComponents     *prometheus.Desc
+	// jobs is labeled by state and jobsDimensions
+	jobs           *prometheus.Desc
+	jobsDimensions []string
+	// pendingReason is broken down by resources only if pendingResources
+	pendingReason     *prometheus.Desc
+	pendingReasonCPUs *prometheus.Desc
+	pendingReasonGPUs *prometheus.Desc
+	pendingResources  bool
+	client            SlurmClient
+	logger            log.Logger
+}
+
+// queueOptions are the options of the queue collector in the configuration
+// file.
+type queueOptions struct {
+	// PendingResources adds the CPUs and GPUs requested per pending reason
+	PendingResources bool `yaml:"pending_resources"`
+	// Dimensions are the labels of slurm_queue_jobs besides the state, a
+	// subset of queueDimensions
+	Dimensions []string `yaml:"dimensions"`
+}
+
+func (o *queueOptions) validate() error {
+	seen := make(map[string]bool)
+	for _, dimension := range o.Dimensions {
+		if !isQueueDimension(dimension) {
+			return fmt.Errorf("queue dimensions must be in %v: %q", queueDimensions, dimension)
+		}
+		if seen[dimension] {
+			return fmt.Errorf("duplicate queue dimension %q", dimension)
+		}
+		seen[dimension] = true
+	}
+	return nil
+}
+
+// defaultQueueOptions takes the dimensions from --collector.queue.dimensions.
+func defaultQueueOptions() interface{} {
+	dimensions := parseList(*queueDimensionsFlag)
+	if dimensions == nil {
+		dimensions = []string{"partition", "qos"}
+	}
+	return &queueOptions{Dimensions: dimensions}
 }
 
 func init() {
 	registerCollector("queue", defaultEnabled, NewQueueCollector)
+	registerCollectorOptions("queue", defaultQueueOptions)
 }
 
-func NewQueueCollector(logger log.Logger) (Collector, error) {
+func NewQueueCollector(logger log.Logger, client SlurmClient) (Collector, error) {
+	options := defaultQueueOptions().(*queueOptions)
+	if err := options.validate(); err != nil {
+		return nil, fmt.Errorf("invalid --collector.queue.dimensions: %w", err)
+	}
+	if err := decodeOptions("queue", options); err != nil {
+		return nil, err
+	}
+	labels := []string{"reason", "partition"}
 	return &QueueCollector{
-		logger:      logger,
-		pending:     prometheus.NewDesc("slurm_queue_pending", "Pending jobs in queue", nil, nil),
-		pendingDep:  prometheus.NewDesc("slurm_queue_pending_dependency", "Pending jobs because of dependency in queue", nil, nil),
-		running:     prometheus.NewDesc("slurm_queue_running", "Running jobs in the cluster", nil, nil),
-		suspended:   prometheus.NewDesc("slurm_queue_suspended", "Suspended jobs in the cluster", nil, nil),
-		cancelled:   prometheus.NewDesc("slurm_queue_cancelled", "Cancelled jobs in the cluster", nil, nil),
-		completing:  prometheus.NewDesc("slurm_queue_completing", "Completing jobs in the cluster", nil, nil),
-		completed:   prometheus.NewDesc("slurm_queue_completed", "Completed jobs in the cluster", nil, nil),
-		configuring: prometheus.NewDesc("slurm_queue_configuring", "Configuring jobs in the cluster", nil, nil),
-		failed:      prometheus.NewDesc("slurm_queue_failed", "Number of failed jobs", nil, nil),
-		timeout:     prometheus.NewDesc("slurm_queue_timeout", "Jobs stopped by timeout", nil, nil),
-		preempted:   prometheus.NewDesc("slurm_queue_preempted", "Number of preempted jobs", nil, nil),
-		nodeFail:    prometheus.NewDesc("slurm_queue_node_fail", "Number of jobs stopped due to node fail", nil, nil),
-		outOfMemory: prometheus.NewDesc("slurm_queue_out_of_memory", "Number of jobs stopped by oomkiller", nil, nil),
+		client:            client,
+		logger:            logger,
+		pendingResources:  options.PendingResources,
+		jobs:              prometheus.NewDesc("slurm_queue_jobs", "Jobs in the queue by state", append([]string{"state"}, options.Dimensions...), nil),
+		jobsDimensions:    options.Dimensions,
+		pendingReason:     prometheus.NewDesc("slurm_queue_pending_reason", "Pending jobs by the reason they are pending for", labels, nil),
+		pendingReasonCPUs: prometheus.NewDesc("slurm_queue_pending_reason_cpus", "CPUs requested by the pending jobs by reason", labels, nil),
+		pendingReasonGPUs: prometheus.NewDesc("slurm_queue_pending_reason_gpus", "GPUs requested by the pending jobs by reason", labels, nil),
+		pending:           prometheus.NewDesc("slurm_queue_pending", "Pending jobs in queue", nil, nil),
+		pendingDep:        prometheus.NewDesc("slurm_queue_pending_dependency", "Pending jobs because of dependency in queue", nil, nil),
+		running:           prometheus.NewDesc("slurm_queue_running", "Running jobs in the cluster", nil, nil),
+		suspended:         prometheus.NewDesc("slurm_queue_suspended", "Suspended jobs in the cluster", nil, nil),
+		cancelled:         prometheus.NewDesc("slurm_queue_cancelled", "Cancelled jobs in the cluster", nil, nil),
+		completing:        prometheus.NewDesc("slurm_queue_completing", "Completing jobs in the cluster", nil, nil),
+		completed:         prometheus.NewDesc("slurm_queue_completed", "Completed jobs in the cluster", nil, nil),
+		configuring:       prometheus.NewDesc("slurm_queue_configuring", "Configuring jobs in the cluster", nil, nil),
+		failed:            prometheus.NewDesc("slurm_queue_failed", "Number of failed jobs", nil, nil),
+		timeout:           prometheus.NewDesc("slurm_queue_timeout", "Jobs stopped by timeout", nil, nil),
+		preempted:         prometheus.NewDesc("slurm_queue_preempted", "Number of preempted jobs", nil, nil),
+		nodeFail:          prometheus.NewDesc("slurm_queue_node_fail", "Number of jobs stopped due to node fail", nil, nil),
+		outOfMemory:       prometheus.NewDesc("slurm_queue_out_of_memory", "Number of jobs stopped by oomkiller", nil, nil),
+		arrayJobs:         prometheus.NewDesc("slurm_queue_array_jobs", "Array jobs with pending or running tasks", nil, nil),
+		arrayTasksPending: prometheus.NewDesc("slurm_queue_array_tasks_pending", "Pending tasks of array jobs", nil, nil),
+		arrayTasksRunning: prometheus.NewDesc("slurm_queue_array_tasks_running", "Running tasks of array jobs", nil, nil),
+		heterogeneous:     prometheus.NewDesc("slurm_queue_heterogeneous_jobs", "Heterogeneous jobs by state", []string{"state"}, nil),
+		hetComponents:     prometheus.NewDesc("slurm_queue_heterogeneous_components", "Components of heterogeneous jobs by state", []string{"state"}, nil),
 	}, nil
 }
 
-func (qc *QueueCollector) Collect(ch chan<- prometheus.Metric) error {
-	out, err := RunCommand("squeue", "-a", "-r", "-h", "-o %A,%T,%r", "--states=all")
+func (qc *QueueCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	jobs, err := qc.client.Jobs(ctx)
 	if err != nil {
 		return err
 	}
 
-	qm := ParseQueueMetrics(out)
+	qm := ParseQueueMetrics(jobs)
 	ch <- prometheus.MustNewConstMetric(qc.pending, prometheus.GaugeValue, qm.pending)
 	ch <- prometheus.MustNewConstMetric(qc.pendingDep, prometheus.GaugeValue, qm.pendingDep)
 	ch <- prometheus.MustNewConstMetric(qc.running, prometheus.GaugeValue, qm.running)
@@ -142,6 +322,30 @@ func (qc *QueueCollector) Collect(ch chan<- prometheus.Metric) error {
 	ch <- prometheus.MustNewConstMetric(qc.preempted, prometheus.GaugeValue, qm.preempted)
 	ch <- prometheus.MustNewConstMetric(qc.nodeFail, prometheus.GaugeValue, qm.nodeFail)
 	ch <- prometheus.MustNewConstMetric(qc.outOfMemory, prometheus.GaugeValue, qm.outOfMemory)
+	ch <- prometheus.MustNewConstMetric(qc.arrayJobs, prometheus.GaugeValue, qm.arrayJobs)
+	ch <- prometheus.MustNewConstMetric(qc.arrayTasksPending, prometheus.GaugeValue, qm.arrayTasksPending)
+	ch <- prometheus.MustNewConstMetric(qc.arrayTasksRunning, prometheus.GaugeValue, qm.arrayTasksRunning)
+	counts := make(map[queueJobKey]float64)
+	values := make(map[queueJobKey][]string)
+	for key, count := range qm.jobs {
+		projected, labels := key.project(qc.jobsDimensions)
+		counts[projected] += count
+		values[projected] = labels
+	}
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(qc.jobs, prometheus.GaugeValue, count, values[key]...)
+	}
+	for state, count := range qm.heterogeneous {
+		ch <- prometheus.MustNewConstMetric(qc.heterogeneous, prometheus.GaugeValue, count, state)
+		ch <- prometheus.MustNewConstMetric(qc.hetComponents, prometheus.GaugeValue, qm.hetComponents[state], state)
+	}
+	for key, reason := range qm.pendingReasons {
+		ch <- prometheus.MustNewConstMetric(qc.pendingReason, prometheus.GaugeValue, reason.jobs, key.reason, key.partition)
+		if qc.pendingResources {
+			ch <- prometheus.MustNewConstMetric(qc.pendingReasonCPUs, prometheus.GaugeValue, reason.cpus, key.reason, key.partition)
+			ch <- prometheus.MustNewConstMetric(qc.pendingReasonGPUs, prometheus.GaugeValue, reason.gpus, key.reason, key.partition)
+		}
+	}
 
 	return nil
 }
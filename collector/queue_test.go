@@ -1,17 +1,22 @@
 package collector
 
 import (
-	"github.com/stretchr/testify/assert"
 	"io"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseQueueMetrics(t *testing.T) {
 	// Read the input data from a file
 	file, _ := os.Open("fixtures/squeue/queue.txt")
 	data, _ := io.ReadAll(file)
-	queueMetrics := ParseQueueMetrics(data)
+	queueMetrics := ParseQueueMetrics(ParseJobs(data))
 
 	assert.Equal(t, 32.0, queueMetrics.pending, "Miscount of pending jobs")
 	assert.Equal(t, 0.0, queueMetrics.pendingDep, "Miscount of pendingDep jobs")
@@ -27,3 +32,156 @@ func TestParseQueueMetrics(t *testing.T) {
 	assert.Equal(t, 0.0, queueMetrics.nodeFail, "Miscount of nodeFail jobs")
 	assert.Equal(t, 0.0, queueMetrics.outOfMemory, "Miscount of outOfMemory jobs")
 }
+
+func TestQueueCollector(t *testing.T) {
+	qc, err := NewQueueCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/queue.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_queue_pending Pending jobs in queue
+# TYPE slurm_queue_pending gauge
+slurm_queue_pending 32
+# HELP slurm_queue_running Running jobs in the cluster
+# TYPE slurm_queue_running gauge
+slurm_queue_running 27
+# HELP slurm_queue_cancelled Cancelled jobs in the cluster
+# TYPE slurm_queue_cancelled gauge
+slurm_queue_cancelled 1
+`
+	c := &registryCollector{Collector: qc}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_queue_pending", "slurm_queue_running", "slurm_queue_cancelled"))
+	assert.NoError(t, c.err)
+	assert.Equal(t, 23, testutil.CollectAndCount(c))
+
+	reasons := `
+# HELP slurm_queue_pending_reason Pending jobs by the reason they are pending for
+# TYPE slurm_queue_pending_reason gauge
+slurm_queue_pending_reason{partition="ampere",reason="QOSMaxGRESPerUser"} 31
+slurm_queue_pending_reason{partition="ampere",reason="Resources"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(reasons), "slurm_queue_pending_reason"))
+}
+
+func TestQueueCollectorPendingResources(t *testing.T) {
+	require.NoError(t, loadTestConfig(t, "collectors:\n  queue:\n    options:\n      pending_resources: true\n"))
+	qc, err := NewQueueCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/pending.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_queue_pending_reason_cpus CPUs requested by the pending jobs by reason
+# TYPE slurm_queue_pending_reason_cpus gauge
+slurm_queue_pending_reason_cpus{partition="cpu",reason="Priority"} 2
+slurm_queue_pending_reason_cpus{partition="debug",reason="Priority"} 1
+slurm_queue_pending_reason_cpus{partition="gpu",reason="Priority"} 4
+slurm_queue_pending_reason_cpus{partition="gpu",reason="Resources"} 4
+# HELP slurm_queue_pending_reason_gpus GPUs requested by the pending jobs by reason
+# TYPE slurm_queue_pending_reason_gpus gauge
+slurm_queue_pending_reason_gpus{partition="cpu",reason="Priority"} 0
+slurm_queue_pending_reason_gpus{partition="debug",reason="Priority"} 0
+slurm_queue_pending_reason_gpus{partition="gpu",reason="Priority"} 1
+slurm_queue_pending_reason_gpus{partition="gpu",reason="Resources"} 1
+`
+	c := &registryCollector{Collector: qc}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_queue_pending_reason_cpus", "slurm_queue_pending_reason_gpus"))
+	assert.NoError(t, c.err)
+}
+
+func TestQueueCollectorJobs(t *testing.T) {
+	qc, err := NewQueueCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/pending.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_queue_jobs Jobs in the queue by state
+# TYPE slurm_queue_jobs gauge
+slurm_queue_jobs{partition="cpu",qos="long",state="PENDING"} 1
+slurm_queue_jobs{partition="cpu",qos="long",state="RUNNING"} 1
+slurm_queue_jobs{partition="debug",qos="normal",state="PENDING"} 1
+slurm_queue_jobs{partition="gpu",qos="normal",state="PENDING"} 2
+`
+	c := &registryCollector{Collector: qc}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_queue_jobs"))
+	assert.NoError(t, c.err)
+}
+
+func TestQueueCollectorJobsDimensions(t *testing.T) {
+	require.NoError(t, loadTestConfig(t, "collectors:\n  queue:\n    options:\n      dimensions: [account]\n"))
+	qc, err := NewQueueCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/pending.txt"})
+	require.NoError(t, err)
+
+	expected := `
+# HELP slurm_queue_jobs Jobs in the queue by state
+# TYPE slurm_queue_jobs gauge
+slurm_queue_jobs{account="account1",state="PENDING"} 2
+slurm_queue_jobs{account="account1",state="RUNNING"} 1
+slurm_queue_jobs{account="account2",state="PENDING"} 2
+`
+	c := &registryCollector{Collector: qc}
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "slurm_queue_jobs"))
+	assert.NoError(t, c.err)
+
+	err = loadTestConfig(t, "collectors:\n  queue:\n    options:\n      dimensions: [user]\n")
+	assert.ErrorContains(t, err, `"user"`)
+}
+
+func TestQueueCollectorDimensionsFlag(t *testing.T) {
+	defer func(value string) { *queueDimensionsFlag = value }(*queueDimensionsFlag)
+	require.NoError(t, loadTestConfig(t, ""))
+
+	*queueDimensionsFlag = "account"
+	qc, err := NewQueueCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/pending.txt"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"account"}, qc.(*QueueCollector).jobsDimensions)
+
+	// the configuration file overrides the flag
+	require.NoError(t, loadTestConfig(t, "collectors:\n  queue:\n    options:\n      dimensions: [partition]\n"))
+	qc, err = NewQueueCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/pending.txt"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"partition"}, qc.(*QueueCollector).jobsDimensions)
+
+	*queueDimensionsFlag = "account,user"
+	_, err = NewQueueCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/pending.txt"})
+	assert.ErrorContains(t, err, "--collector.queue.dimensions")
+}
+
+func TestPendingReason(t *testing.T) {
+	assert.Equal(t, "ReqNodeNotAvail", pendingReason("ReqNodeNotAvail, UnavailableNodes:node[01-02]"))
+	assert.Equal(t, "AssocGrpGRES", pendingReason("AssocGrpGRES"))
+	assert.Equal(t, "None", pendingReason(""))
+}
+
+func TestParseQueueMetricsArrays(t *testing.T) {
+	data, err := os.ReadFile("fixtures/squeue/array.txt")
+	require.NoError(t, err)
+	queueMetrics := ParseQueueMetrics(ParseJobs(data))
+
+	// 6 pending tasks of 96000, 5 of 96010 and one of 96020, plus 96030
+	assert.Equal(t, 13.0, queueMetrics.pending)
+	assert.Equal(t, 1.0, queueMetrics.pendingDep)
+	assert.Equal(t, 4.0, queueMetrics.running)
+	assert.Equal(t, 3.0, queueMetrics.arrayJobs)
+	assert.Equal(t, 12.0, queueMetrics.arrayTasksPending)
+	assert.Equal(t, 4.0, queueMetrics.arrayTasksRunning)
+
+	reason := queueMetrics.pendingReasons[pendingReasonKey{reason: "Priority", partition: "gpu"}]
+	require.NotNil(t, reason)
+	assert.Equal(t, 5.0, reason.jobs)
+	assert.Equal(t, 20.0, reason.cpus)
+	assert.Equal(t, 5.0, reason.gpus)
+}
+
+func TestParseQueueMetricsHeterogeneous(t *testing.T) {
+	data, err := os.ReadFile("fixtures/squeue/het.txt")
+	require.NoError(t, err)
+	queueMetrics := ParseQueueMetrics(ParseJobs(data))
+
+	assert.Equal(t, 2.0, queueMetrics.pending)
+	assert.Equal(t, 1.0, queueMetrics.running)
+	assert.Equal(t, map[string]float64{"PENDING": 1, "RUNNING": 1}, queueMetrics.heterogeneous)
+	assert.Equal(t, map[string]float64{"PENDING": 3, "RUNNING": 2}, queueMetrics.hetComponents)
+
+	// the CPUs of every component are requested
+	reason := queueMetrics.pendingReasons[pendingReasonKey{reason: "Resources", partition: "cpu"}]
+	require.NotNil(t, reason)
+	assert.Equal(t, 1.0, reason.jobs)
+	assert.Equal(t, 4.0, reason.cpus)
+}
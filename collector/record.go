@@ -0,0 +1,409 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	recordDir   = kingpin.Flag("slurm.record-dir", "Write the command line, exit code, stderr and stdout of every Slurm command into this directory, in the layout read by --slurm.replay-dir.").String()
+	recordScrub = kingpin.Flag("slurm.record-scrub", "Replace user and account names with placeholders in the recorded output.").Bool()
+
+	shellSafe = regexp.MustCompile(`^[A-Za-z0-9_.,=:/@%+-]+$`)
+	nameToken = regexp.MustCompile(`[A-Za-z0-9_.-]+`)
+)
+
+// RecordOnce runs every enabled collector once, so that their Slurm commands
+// are written to --slurm.record-dir.
+func RecordOnce(logger log.Logger) error {
+	if *recordDir == "" {
+		return errors.New("--slurm.record-dir is required")
+	}
+	clusters, err := configuredClusters()
+	if err != nil {
+		return err
+	}
+
+	for _, cluster := range clusters {
+		client, err := clientFor(cluster)
+		if err != nil {
+			return err
+		}
+
+		// the collectors are created without background refresh, which
+		// would not have run yet when the scrape below returns
+		collectors := make(map[string]Collector)
+		for name := range collectorState {
+			if !isEnabled(name) {
+				continue
+			}
+			c, err := createCollector(name, cluster.name, client, logger)
+			if err != nil {
+				return err
+			}
+			collectors[name] = c
+		}
+
+		ch := make(chan prometheus.Metric)
+		go func() {
+			SlurmCollector{Collectors: collectors, cluster: cluster.name, logger: logger}.Collect(ch)
+			close(ch)
+		}()
+		for range ch {
+		}
+	}
+	return nil
+}
+
+// recorder runs Slurm commands and writes their results into a directory:
+// stdout to the file read by the replayer, stderr next to it with the .err
+// suffix, and the command line with its exit code with the .cmd suffix.
+type recorder struct {
+	dir string
+	// scrubber is nil when names are recorded as they are
+	scrubber *scrubber
+
+	// recordings keeps the unscrubbed results by file name while scrubbing,
+	// they are written again whenever a command reveals new names
+	mtx        sync.Mutex
+	recordings map[string]recording
+}
+
+// recording is the result of a single command.
+type recording struct {
+	env        []string
+	executable string
+	arguments  []string
+	stdout     []byte
+	stderr     []byte
+	exitCode   int
+}
+
+func newRecorder(dir string, scrub bool) *recorder {
+	r := &recorder{dir: dir}
+	if scrub {
+		r.scrubber = &scrubber{names: make(map[string]string), kept: make(map[string]bool)}
+		r.recordings = make(map[string]recording)
+	}
+	return r
+}
+
+// run is a runFunc.
+func (r *recorder) run(ctx context.Context, env []string, executable string, arguments ...string) ([]byte, error) {
+	stdout, stderr, err := runCommand(ctx, env, executable, arguments...)
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		// the command did not run or was killed, keep the reason instead
+		exitCode = -1
+		stderr = append(stderr, err.Error()+"\n"...)
+	}
+
+	rec := recording{env: env, executable: executable, arguments: arguments, stdout: stdout, stderr: stderr, exitCode: exitCode}
+	if writeErr := r.write(rec); writeErr != nil && err == nil {
+		err = fmt.Errorf("record %s: %w", executable, writeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stdout, nil
+}
+
+// write records the result of a command. While scrubbing, the commands run
+// concurrently and each may reveal names which already appear in the others,
+// so every recording is scrubbed again once new names are learned.
+func (r *recorder) write(rec recording) error {
+	name := replayKey(rec.executable, rec.arguments)
+	if r.scrubber == nil {
+		return r.save(name, rec)
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.recordings[name] = rec
+	if !r.scrubber.learn(filepath.Base(rec.executable), rec.arguments, rec.stdout) {
+		return r.save(name, r.scrubber.scrubRecording(rec))
+	}
+	for name, rec := range r.recordings {
+		if err := r.save(name, r.scrubber.scrubRecording(rec)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *recorder) save(name string, rec recording) error {
+	name = filepath.Join(r.dir, name)
+	base := strings.TrimSuffix(name, ".txt")
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(name, rec.stdout, 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".err", rec.stderr, 0644); err != nil {
+		return err
+	}
+	command := fmt.Sprintf("%s\nexit code %d\n", shellQuote(append(append(append([]string{}, rec.env...), rec.executable), rec.arguments...)), rec.exitCode)
+	return os.WriteFile(base+".cmd", []byte(command), 0644)
+}
+
+// recordedExitCode returns the exit code stored in a .cmd file.
+func recordedExitCode(name string) (int, bool) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range SplitLines(data) {
+		if code, ok := strings.CutPrefix(line, "exit code "); ok {
+			exitCode, err := strconv.Atoi(code)
+			return exitCode, err == nil
+		}
+	}
+	return 0, false
+}
+
+// shellQuote joins a command line, quoting the arguments the shell would
+// interpret, e.g. the format strings of squeue.
+func shellQuote(arguments []string) string {
+	quoted := make([]string, len(arguments))
+	for i, arg := range arguments {
+		if shellSafe.MatchString(arg) {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// scrubber replaces user and account names with placeholders such as user1
+// or account1, which stay the same across all recorded commands. The names
+// are learned from the output of the commands listing them and replaced
+// wherever they appear as a whole word. Partition names are kept, even where
+// an account has the same name.
+type scrubber struct {
+	mtx      sync.Mutex
+	names    map[string]string
+	kept     map[string]bool
+	users    int
+	accounts int
+}
+
+// learn adds the names listed in the output of a command, it reports whether
+// any of them changes what is scrubbed.
+func (s *scrubber) learn(command string, arguments []string, out []byte) bool {
+	isJSON := bytes.HasPrefix(bytes.TrimSpace(out), []byte("{"))
+	var users, accounts, partitions []string
+	switch command {
+	case "squeue":
+		var jobs []Job
+		if isJSON {
+			jobs, _ = ParseJobsJSON(out)
+		} else {
+			jobs = ParseJobs(out)
+		}
+		for _, job := range jobs {
+			users = append(users, job.user)
+			accounts = append(accounts, job.account)
+			partitions = append(partitions, job.partition)
+		}
+	case "sacct":
+		var jobs []EndedJob
+		if isJSON {
+			jobs, _ = ParseEndedJobsJSON(out)
+		} else {
+			jobs = ParseEndedJobs(out)
+		}
+		for _, job := range jobs {
+			users = append(users, job.user)
+			accounts = append(accounts, job.account)
+			partitions = append(partitions, job.partition)
+		}
+	case "sinfo":
+		// sinfo -R lists the users who set the reasons of the nodes, the
+		// other calls the partitions
+		if containsString(arguments, "-R") {
+			for _, reason := range ParseNodeReasons(out) {
+				users = append(users, reason.user)
+			}
+			break
+		}
+		var nodes []Node
+		if isJSON {
+			nodes, _ = ParseNodesJSON(out)
+		} else {
+			nodes = ParseNodes(out)
+		}
+		for _, node := range nodes {
+			partitions = append(partitions, node.partitions...)
+		}
+	case "scontrol":
+		users, partitions = parseScontrolNodeNames(out, isJSON)
+	case "sshare":
+		for account := range ParseFairShareMetrics(out) {
+			accounts = append(accounts, account)
+		}
+	case "sdiag":
+		users = parseSdiagUsers(out, isJSON)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	learned := false
+	for _, partition := range partitions {
+		if partition != "" && !s.kept[partition] {
+			s.kept[partition] = true
+			_, renamed := s.names[partition]
+			delete(s.names, partition)
+			learned = learned || renamed
+		}
+	}
+	for _, user := range users {
+		if _, ok := s.names[user]; !ok && !s.kept[user] && isScrubbed(user) {
+			s.users++
+			s.names[user] = fmt.Sprintf("user%d", s.users)
+			learned = true
+		}
+	}
+	for _, account := range accounts {
+		if _, ok := s.names[account]; !ok && !s.kept[account] && isScrubbed(account) {
+			s.accounts++
+			s.names[account] = fmt.Sprintf("account%d", s.accounts)
+			learned = true
+		}
+	}
+	return learned
+}
+
+// containsString reports whether a list contains a value.
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isScrubbed reports whether a name is worth replacing.
+func isScrubbed(name string) bool {
+	return name != "" && name != "root" && name != "(null)"
+}
+
+// scrubRecording returns a copy of a recording with its output scrubbed.
+func (s *scrubber) scrubRecording(rec recording) recording {
+	rec.stdout = s.scrub(rec.stdout)
+	rec.stderr = s.scrub(rec.stderr)
+	return rec
+}
+
+func (s *scrubber) scrub(out []byte) []byte {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return nameToken.ReplaceAllFunc(out, func(token []byte) []byte {
+		if placeholder, ok := s.names[string(token)]; ok {
+			return []byte(placeholder)
+		}
+		return token
+	})
+}
+
+// parseSdiagUsers returns the users listed in the RPC statistics of sdiag.
+func parseSdiagUsers(out []byte, isJSON bool) []string {
+	var users []string
+	if isJSON {
+		var response struct {
+			Statistics struct {
+				RPCsByUser []struct {
+					User string `json:"user"`
+				} `json:"rpcs_by_user"`
+			} `json:"statistics"`
+		}
+		if err := json.Unmarshal(out, &response); err == nil {
+			for _, rpc := range response.Statistics.RPCsByUser {
+				users = append(users, rpc.User)
+			}
+		}
+		return users
+	}
+
+	inSection := false
+	for _, line := range SplitLines(out) {
+		switch {
+		case strings.Contains(line, "statistics by user"):
+			inSection = true
+		case strings.TrimSpace(line) == "":
+			inSection = false
+		case inSection:
+			users = append(users, strings.Fields(line)[0])
+		}
+	}
+	return users
+}
+
+// scontrolReasonUser matches the user and time scontrol appends to the reason
+// of a node, e.g. "Not responding [slurm@2024-06-19T07:45:12]".
+var scontrolReasonUser = regexp.MustCompile(`\[([^@\]]+)@[^\]]*\]$`)
+
+// parseScontrolNodeNames returns the users who set the reasons of the nodes
+// and the partitions listed by scontrol show node.
+func parseScontrolNodeNames(out []byte, isJSON bool) ([]string, []string) {
+	var users, partitions []string
+	if isJSON {
+		var response struct {
+			Nodes []struct {
+				ReasonSetByUser string   `json:"reason_set_by_user"`
+				Partitions      []string `json:"partitions"`
+			} `json:"nodes"`
+		}
+		if err := json.Unmarshal(out, &response); err == nil {
+			for _, node := range response.Nodes {
+				users = append(users, node.ReasonSetByUser)
+				partitions = append(partitions, node.Partitions...)
+			}
+		}
+		return users, partitions
+	}
+
+	for _, line := range SplitLines(out) {
+		fields := parseScontrolFields(strings.TrimSpace(line))
+		if match := scontrolReasonUser.FindStringSubmatch(fields["Reason"]); match != nil {
+			users = append(users, match[1])
+		}
+		partitions = append(partitions, parseList(fields["Partitions"])...)
+	}
+	return users, partitions
+}
@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCommand writes a script named like a Slurm command into dir.
+func fakeCommand(t *testing.T, dir, name, script string) string {
+	executable := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(executable, []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	return executable
+}
+
+func TestRecorder(t *testing.T) {
+	bin, dir := t.TempDir(), t.TempDir()
+	fixture, err := filepath.Abs("fixtures/squeue/user.txt")
+	require.NoError(t, err)
+	squeue := fakeCommand(t, bin, "squeue", "cat "+fixture)
+
+	r := newRecorder(dir, false)
+	out, err := r.run(context.Background(), nil, squeue, "-o", "%A|%u")
+	require.NoError(t, err)
+	assert.Equal(t, readFixture(t, fixture), out)
+
+	assert.Equal(t, out, readFixture(t, filepath.Join(dir, "squeue/-o_A_u.txt")))
+	assert.Equal(t, fmt.Sprintf("%s -o '%%A|%%u'\nexit code 0\n", squeue), string(readFixture(t, filepath.Join(dir, "squeue/-o_A_u.cmd"))))
+	assert.Empty(t, readFixture(t, filepath.Join(dir, "squeue/-o_A_u.err")))
+}
+
+func TestRecorderFailure(t *testing.T) {
+	bin, dir := t.TempDir(), t.TempDir()
+	sdiag := fakeCommand(t, bin, "sdiag", "echo 'Unable to contact slurm controller' >&2; exit 1")
+
+	_, err := newRecorder(dir, false).run(context.Background(), nil, sdiag)
+	assert.ErrorContains(t, err, "Unable to contact slurm controller")
+
+	// the replayer reproduces the failure
+	replay, err := newReplayer(dir)
+	require.NoError(t, err)
+	_, err = replay.run(context.Background(), nil, "sdiag")
+	assert.ErrorContains(t, err, "exit code 1: Unable to contact slurm controller")
+}
+
+func TestRecorderScrub(t *testing.T) {
+	bin, dir := t.TempDir(), t.TempDir()
+	fixture, err := filepath.Abs("fixtures/squeue/user.txt")
+	require.NoError(t, err)
+	squeue := fakeCommand(t, bin, "squeue", "cat "+fixture)
+
+	_, err = newRecorder(dir, true).run(context.Background(), nil, squeue)
+	require.NoError(t, err)
+
+	original := ParseJobs(readFixture(t, fixture))
+	scrubbed := ParseJobs(readFixture(t, filepath.Join(dir, "squeue/squeue.txt")))
+	require.Len(t, scrubbed, len(original))
+	placeholders := make(map[string]string)
+	for i, job := range scrubbed {
+		assert.Equal(t, original[i].id, job.id)
+		// partition names are kept, and so is the account sharing its name
+		assert.Equal(t, "ampere", job.account)
+		assert.Equal(t, "ampere", job.partition)
+		if placeholder, ok := placeholders[original[i].user]; ok {
+			assert.Equal(t, placeholder, job.user)
+		}
+		placeholders[original[i].user] = job.user
+	}
+	assert.Len(t, placeholders, 8)
+	assert.NotContains(t, placeholders, "")
+}
+
+// The names learned from a command are scrubbed from the commands recorded
+// before it as well.
+func TestRecorderScrubLearnedLater(t *testing.T) {
+	bin, dir := t.TempDir(), t.TempDir()
+	fixture, err := filepath.Abs("fixtures/scontrol/node.txt")
+	require.NoError(t, err)
+	sdiag := fakeCommand(t, bin, "sdiag", "echo 'Last drain by slurm'")
+	scontrol := fakeCommand(t, bin, "scontrol", "cat "+fixture)
+
+	r := newRecorder(dir, true)
+	_, err = r.run(context.Background(), nil, sdiag)
+	require.NoError(t, err)
+	assert.Equal(t, "Last drain by slurm\n", string(readFixture(t, filepath.Join(dir, "sdiag/sdiag.txt"))))
+
+	_, err = r.run(context.Background(), nil, scontrol, "show", "node", "-o")
+	require.NoError(t, err)
+	assert.Equal(t, "Last drain by user1\n", string(readFixture(t, filepath.Join(dir, "sdiag/sdiag.txt"))))
+	recorded := string(readFixture(t, filepath.Join(dir, "scontrol/show_node_-o.txt")))
+	assert.Contains(t, recorded, "Reason=Not responding [user1@2024-06-19T07:45:12]")
+	assert.Contains(t, recorded, "Partitions=gpu")
+}
+
+func TestParseScontrolNodeNames(t *testing.T) {
+	users, partitions := parseScontrolNodeNames(readFixture(t, "fixtures/scontrol/node.txt"), false)
+	assert.Contains(t, users, "slurm")
+	assert.Contains(t, partitions, "gpu")
+
+	users, partitions = parseScontrolNodeNames([]byte(`{"nodes": [{"reason_set_by_user": "admin1", "partitions": ["cpu", "gpu"]}]}`), true)
+	assert.Equal(t, []string{"admin1"}, users)
+	assert.Equal(t, []string{"cpu", "gpu"}, partitions)
+}
+
+func TestParseSdiagUsers(t *testing.T) {
+	users := parseSdiagUsers(readFixture(t, "fixtures/sdiag/sdiag.txt"), false)
+	assert.Equal(t, []string{"user1", "user2", "user3", "user4", "user5", "user6", "user7", "user8", "user9", "user10"}, users)
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `sinfo -h -O 'NodeList:|,StateLong:' 'it'\''s'`, shellQuote([]string{"sinfo", "-h", "-O", "NodeList:|,StateLong:", "it's"}))
+}
@@ -0,0 +1,122 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	replayDir = kingpin.Flag("slurm.replay-dir", "Answer the Slurm commands from the files in this directory instead of running them.").String()
+
+	unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9.,=-]+`)
+)
+
+// replayer answers Slurm commands from recorded output. The directory has a
+// subdirectory per command, e.g. sinfo/ or squeue/, or numbered snapshots
+// each holding such subdirectories, which are cycled through per command.
+type replayer struct {
+	snapshots []string
+
+	mtx  sync.Mutex
+	next map[string]int
+}
+
+func newReplayer(dir string) (*replayer, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read replay directory: %w", err)
+	}
+
+	numbers := make(map[string]int)
+	var snapshots []string
+	for _, entry := range entries {
+		if n, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			numbers[entry.Name()] = n
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return numbers[snapshots[i]] < numbers[snapshots[j]] })
+
+	r := &replayer{next: make(map[string]int)}
+	for _, snapshot := range snapshots {
+		r.snapshots = append(r.snapshots, filepath.Join(dir, snapshot))
+	}
+	if len(r.snapshots) == 0 {
+		r.snapshots = []string{dir}
+	}
+	return r, nil
+}
+
+// run returns the recorded output of a command, it is a runFunc.
+func (r *replayer) run(ctx context.Context, env []string, executable string, arguments ...string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("run command %s: %w", executable, err)
+	}
+
+	key := replayKey(executable, arguments)
+	r.mtx.Lock()
+	snapshot := r.snapshots[r.next[key]]
+	r.next[key] = (r.next[key] + 1) % len(r.snapshots)
+	r.mtx.Unlock()
+
+	// a failed command is replayed with its error, see recorder
+	base := strings.TrimSuffix(filepath.Join(snapshot, key), ".txt")
+	if exitCode, ok := recordedExitCode(base + ".cmd"); ok && exitCode != 0 {
+		stderr, _ := os.ReadFile(base + ".err")
+		return nil, fmt.Errorf("replay %s: exit code %d: %s", executable, exitCode, bytes.TrimSpace(stderr))
+	}
+
+	out, err := os.ReadFile(filepath.Join(snapshot, key))
+	if err != nil {
+		return nil, fmt.Errorf("replay %s: %w", executable, err)
+	}
+	return out, nil
+}
+
+// replayKey returns the file holding the output of a command, relative to a
+// snapshot, e.g. "sshare/-n_-P_-o_account,fairshare.txt" for
+// "sshare -n -P -o account,fairshare" or "sdiag/sdiag.txt" for a command
+// without arguments. The time window passed to sacct changes on every call
+// and is left out.
+func replayKey(executable string, arguments []string) string {
+	command := filepath.Base(executable)
+	var parts []string
+	for _, arg := range arguments {
+		if command == "sacct" && (strings.HasPrefix(arg, "-S") || strings.HasPrefix(arg, "-E")) {
+			continue
+		}
+		parts = append(parts, arg)
+	}
+
+	name := command
+	if len(parts) > 0 {
+		name = strings.Trim(unsafeFileChars.ReplaceAllString(strings.Join(parts, "_"), "_"), "_")
+	}
+	return filepath.Join(command, name+".txt")
+}
@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayKey(t *testing.T) {
+	assert.Equal(t, "sdiag/sdiag.txt", replayKey("sdiag", nil))
+	assert.Equal(t, "sinfo/--version.txt", replayKey("/usr/bin/sinfo", []string{"--version"}))
+	assert.Equal(t, "sshare/-n_-P_-o_account,fairshare.txt", replayKey("sshare", []string{"-n", "-P", "-o", "account,fairshare"}))
+	assert.Equal(t, "squeue/-a_-r_-h_--states=all_-o_A_u_a_P.txt", replayKey("squeue", []string{"-a", "-r", "-h", "--states=all", "-o", "%A|%u|%a|%P"}))
+	assert.Equal(t, "sacct/--state=COMPLETED_-X_-a_--json.txt", replayKey("sacct", []string{"--state=COMPLETED", "-S2024-06-19T00:00:00", "-E2024-06-20T00:00:00", "-X", "-a", "--json"}))
+}
+
+// writeReplayFile copies a fixture to where the replayer looks up a command.
+func writeReplayFile(t *testing.T, dir, fixture string, executable string, arguments ...string) {
+	name := filepath.Join(dir, replayKey(executable, arguments))
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+	require.NoError(t, os.WriteFile(name, readFixture(t, fixture), 0644))
+}
+
+func TestReplayer(t *testing.T) {
+	dir := t.TempDir()
+	jobsArgs := []string{"-a", "-h", "--states=all", "-o", squeueFormat}
+	writeReplayFile(t, filepath.Join(dir, "1"), "fixtures/squeue/user.txt", "squeue", jobsArgs...)
+	writeReplayFile(t, filepath.Join(dir, "2"), "fixtures/squeue/queue.txt", "squeue", jobsArgs...)
+	writeReplayFile(t, filepath.Join(dir, "1"), "fixtures/sdiag/sdiag.txt", "sdiag")
+	// without a recorded sinfo --version
+	writeReplayFile(t, filepath.Join(dir, "1"), "fixtures/sinfo/node.txt", "sinfo", "-h", "-a", "-N", "-O", sinfoFormat)
+
+	r, err := newReplayer(dir)
+	require.NoError(t, err)
+	client := &cliClient{output: "text", run: r.run}
+	ctx := context.Background()
+
+	// the snapshots are cycled through on every call
+	for _, fixture := range []string{"user.txt", "queue.txt", "user.txt"} {
+		jobs, err := client.Jobs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, ParseJobs(readFixture(t, "fixtures/squeue/"+fixture)), jobs)
+	}
+
+	nodes, err := client.Nodes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ParseNodes(readFixture(t, "fixtures/sinfo/node.txt")), nodes)
+
+	diag, err := client.Diag(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ParseSchedulerMetrics(readFixture(t, "fixtures/sdiag/sdiag.txt")), diag)
+
+	// the second snapshot misses sdiag
+	_, err = client.Diag(ctx)
+	assert.ErrorContains(t, err, "replay sdiag")
+}
@@ -0,0 +1,184 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	restURL        = kingpin.Flag("slurm.rest-url", "Base URL of slurmrestd, used with --slurm.backend=rest.").String()
+	restTokenFile  = kingpin.Flag("slurm.rest-token-file", "File holding the JWT token for slurmrestd, it is read on every request.").String()
+	restUser       = kingpin.Flag("slurm.rest-user", "User name sent to slurmrestd along with the token.").String()
+	restAPIVersion = kingpin.Flag("slurm.rest-api-version", "OpenAPI version of the slurmrestd endpoints.").Default("v0.0.40").String()
+)
+
+// restClient reads the Slurm data from the JSON endpoints of slurmrestd.
+type restClient struct {
+	url        string
+	version    string
+	user       string
+	tokenFile  string
+	httpClient *http.Client
+}
+
+func newRestClient() (*restClient, error) {
+	if *restURL == "" {
+		return nil, errors.New("--slurm.rest-url is required")
+	}
+	return &restClient{
+		url:        strings.TrimSuffix(*restURL, "/"),
+		version:    *restAPIVersion,
+		user:       *restUser,
+		tokenFile:  *restTokenFile,
+		httpClient: http.DefaultClient,
+	}, nil
+}
+
+// get returns the body of a slurmctld endpoint of slurmrestd, e.g. "/jobs".
+func (r *restClient) get(ctx context.Context, endpoint string) ([]byte, error) {
+	return r.request(ctx, "slurm", endpoint, nil)
+}
+
+// accountingJobs returns the body of the slurmdbd jobs endpoint, which
+// lists the jobs like sacct --json, with their steps.
+func (r *restClient) accountingJobs(ctx context.Context, states string, start, end time.Time) ([]byte, error) {
+	return r.request(ctx, "slurmdb", "/jobs", url.Values{
+		"state":      {states},
+		"start_time": {start.Format("2006-01-02T15:04:05")},
+		"end_time":   {end.Format("2006-01-02T15:04:05")},
+	})
+}
+
+// request returns the body of an endpoint of one of the slurmrestd APIs,
+// "slurm" or "slurmdb".
+func (r *restClient) request(ctx context.Context, api, endpoint string, query url.Values) ([]byte, error) {
+	target := fmt.Sprintf("%s/%s/%s%s", r.url, api, r.version, endpoint)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.tokenFile != "" {
+		token, err := os.ReadFile(r.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read token: %w", err)
+		}
+		req.Header.Set("X-SLURM-USER-TOKEN", strings.TrimSpace(string(token)))
+	}
+	if r.user != "" {
+		req.Header.Set("X-SLURM-USER-NAME", r.user)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("request %s: %w", endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request %s: unexpected status %s", endpoint, resp.Status)
+	}
+	return body, nil
+}
+
+func (r *restClient) Jobs(ctx context.Context) ([]Job, error) {
+	body, err := r.get(ctx, "/jobs")
+	if err != nil {
+		return nil, err
+	}
+	return ParseJobsJSON(body)
+}
+
+func (r *restClient) Nodes(ctx context.Context) ([]Node, error) {
+	body, err := r.get(ctx, "/nodes")
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodesJSON(body)
+}
+
+func (r *restClient) NodeDetails(ctx context.Context) ([]NodeDetails, error) {
+	body, err := r.get(ctx, "/nodes")
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodeDetailsJSON(body)
+}
+
+func (r *restClient) Diag(ctx context.Context) (*SchedulerMetrics, error) {
+	body, err := r.get(ctx, "/diag")
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchedulerMetricsJSON(body)
+}
+
+func (r *restClient) Shares(ctx context.Context) (map[string]*FairShareMetrics, error) {
+	body, err := r.get(ctx, "/shares")
+	if err != nil {
+		return nil, err
+	}
+	return ParseFairShareMetricsJSON(body)
+}
+
+func (r *restClient) EndedJobs(ctx context.Context, start, end time.Time) ([]EndedJob, error) {
+	body, err := r.accountingJobs(ctx, endedJobStates, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEndedJobsJSON(body)
+}
+
+func (r *restClient) StartedJobs(ctx context.Context, start, end time.Time) ([]StartedJob, error) {
+	body, err := r.accountingJobs(ctx, "RUNNING", start, end)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStartedJobsJSON(body)
+}
+
+func (r *restClient) JobUsage(ctx context.Context, start, end time.Time) ([]JobUsage, error) {
+	body, err := r.accountingJobs(ctx, endedJobStates, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJobUsageJSON(body)
+}
+
+func (r *restClient) NodeReasons(ctx context.Context) ([]NodeReason, error) {
+	body, err := r.get(ctx, "/nodes")
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodeReasonsJSON(body)
+}
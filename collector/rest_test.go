@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRestClient serves the recorded slurmrestd responses from the
+// fixtures, they describe the same cluster state as the text fixtures.
+func newTestRestClient(t *testing.T) *restClient {
+	tokenFile := path.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0600))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-SLURM-USER-TOKEN") != "secret" || r.Header.Get("X-SLURM-USER-NAME") != "slurm" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch dir, endpoint := path.Split(r.URL.Path); {
+		case dir == "/slurm/v0.0.40/":
+			http.ServeFile(w, r, "fixtures/slurmrestd/"+endpoint+".json")
+		case r.URL.Path == "/slurmdb/v0.0.40/jobs" && r.URL.Query().Get("start_time") != "":
+			// the accounting jobs come with their steps, like sacct --json
+			if r.URL.Query().Get("state") == "RUNNING" {
+				http.ServeFile(w, r, "fixtures/sacct/slurm-23.11.4/started.json")
+			} else {
+				http.ServeFile(w, r, "fixtures/sacct/slurm-23.11.4/usage.json")
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return &restClient{
+		url:        server.URL,
+		version:    "v0.0.40",
+		user:       "slurm",
+		tokenFile:  tokenFile,
+		httpClient: server.Client(),
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	return data
+}
+
+func TestRestClient(t *testing.T) {
+	src := newTestRestClient(t)
+	ctx := context.Background()
+
+	jobs, err := src.Jobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ParseJobs(readFixture(t, "fixtures/squeue/user.txt")), jobs)
+
+	nodes, err := src.Nodes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ParseNodes(readFixture(t, "fixtures/sinfo/node.txt")), nodes)
+
+	diag, err := src.Diag(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ParseSchedulerMetrics(readFixture(t, "fixtures/sdiag/sdiag.txt")), diag)
+
+	shares, err := src.Shares(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ParseFairShareMetrics(readFixture(t, "fixtures/sshare/sshare.txt")), shares)
+
+	reasons, err := src.NodeReasons(ctx)
+	require.NoError(t, err)
+	require.Len(t, reasons, 4)
+	assert.Equal(t, NodeReason{node: "gpunode02", state: "draining", user: "alice", reason: "GPU XID 79", since: 1718719200}, reasons[0])
+	assert.Equal(t, NodeReason{node: "gpunode102", state: "drained", user: "bob", reason: "bad DIMM", since: 1718530200}, reasons[3])
+}
+
+func TestRestClientAccounting(t *testing.T) {
+	src := newTestRestClient(t)
+	ctx := context.Background()
+	start, end := time.Now().Add(-time.Hour), time.Now()
+
+	ended, err := src.EndedJobs(ctx, start, end)
+	require.NoError(t, err)
+	expectedEnded, err := ParseEndedJobsJSON(readFixture(t, "fixtures/sacct/slurm-23.11.4/usage.json"))
+	require.NoError(t, err)
+	assert.Equal(t, expectedEnded, ended)
+
+	// the text fixtures were recorded in UTC
+	started, err := src.StartedJobs(ctx, start, end)
+	require.NoError(t, err)
+	assert.Equal(t, parseStartedJobs(readFixture(t, "fixtures/sacct/started.txt"), time.UTC), started)
+
+	usage, err := src.JobUsage(ctx, start, end)
+	require.NoError(t, err)
+	assert.Equal(t, parseJobUsage(readFixture(t, "fixtures/sacct/usage.txt"), time.UTC), usage)
+}
+
+func TestRestClientUnauthorized(t *testing.T) {
+	src := newTestRestClient(t)
+	src.user = "nobody"
+
+	_, err := src.Jobs(context.Background())
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestNodeStateLong(t *testing.T) {
+	assert.Equal(t, "idle", nodeStateLong([]string{"IDLE"}))
+	assert.Equal(t, "drained*", nodeStateLong([]string{"IDLE", "DRAIN", "NOT_RESPONDING"}))
+	assert.Equal(t, "draining", nodeStateLong([]string{"MIXED", "DRAIN"}))
+	assert.Equal(t, "completing", nodeStateLong([]string{"IDLE", "COMPLETING"}))
+	assert.Equal(t, "idle~", nodeStateLong([]string{"IDLE", "POWERED_DOWN"}))
+	assert.Equal(t, "down*", nodeStateLong([]string{"DOWN", "NOT_RESPONDING"}))
+}
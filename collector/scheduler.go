@@ -18,6 +18,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
+	"context"
 	"regexp"
 	"strconv"
 	"strings"
@@ -114,6 +115,7 @@ type SchedulerCollector struct {
 	totalBackfilledJobsSinceStart *prometheus.Desc
 	totalBackfilledJobsSinceCycle *prometheus.Desc
 	totalBackfilledHeterogeneous  *prometheus.Desc
+	client                        SlurmClient
 	logger                        log.Logger
 }
 
@@ -121,8 +123,9 @@ func init() {
 	registerCollector("scheduler", defaultEnabled, NewSchedulerCollector)
 }
 
-func NewSchedulerCollector(logger log.Logger) (Collector, error) {
+func NewSchedulerCollector(logger log.Logger, client SlurmClient) (Collector, error) {
 	return &SchedulerCollector{
+		client:                        client,
 		logger:                        logger,
 		threads:                       prometheus.NewDesc("slurm_scheduler_threads", "Information provided by the Slurm sdiag command, number of scheduler threads ", nil, nil),
 		queueSize:                     prometheus.NewDesc("slurm_scheduler_queue_size", "Information provided by the Slurm sdiag command, length of the scheduler queue", nil, nil),
@@ -139,13 +142,12 @@ func NewSchedulerCollector(logger log.Logger) (Collector, error) {
 	}, nil
 }
 
-func (sc *SchedulerCollector) Collect(ch chan<- prometheus.Metric) error {
-	out, err := RunCommand("sdiag")
+func (sc *SchedulerCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	sm, err := sc.client.Diag(ctx)
 	if err != nil {
 		return err
 	}
 
-	sm := ParseSchedulerMetrics(out)
 	ch <- prometheus.MustNewConstMetric(sc.threads, prometheus.GaugeValue, sm.threads)
 	ch <- prometheus.MustNewConstMetric(sc.queueSize, prometheus.GaugeValue, sm.queueSize)
 	ch <- prometheus.MustNewConstMetric(sc.dbdQueueSize, prometheus.GaugeValue, sm.dbdQueueSize)
@@ -0,0 +1,499 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// squeueFormat lists every job field used by the squeue based collectors,
+// so that a single squeue call per scrape serves all of them.
+const squeueFormat = "%A|%u|%a|%P|%T|%C|%m|%r|%q|%M|%L|%b|%D|%V|%F|%K|%i|%S"
+
+// sinfoFormat lists every node field used by the sinfo based collectors.
+// sinfoStateComplete is appended for the versions of sinfo which know it.
+const (
+	sinfoFormat        = "NodeList:|,PartitionName:|,AllocMem:|,Memory:|,CPUsState:|,StateLong:|,Gres:|,GresUsed:|,Features:|,FeaturesAct:"
+	sinfoStateComplete = "|,StateComplete:"
+)
+
+// Job is a single job as reported by squeue.
+type Job struct {
+	id        string
+	user      string
+	account   string
+	partition string
+	state     string
+	cpus      float64
+	memory    float64
+	reason    string
+	qos       string
+	// timeUsed and timeLeft are in seconds, timeLeft is only known for jobs
+	// with a time limit
+	timeUsed    float64
+	timeLeft    float64
+	timeLimited bool
+	gpus        float64
+	// submit is a Unix timestamp
+	submit int64
+	// arrayJobID is only set for the tasks of an array job. Without -r,
+	// squeue lists the pending tasks of an array as one job, arrayTasks
+	// is their number then.
+	arrayJobID string
+	arrayTasks float64
+	// hetJobID is only set for the components of a heterogeneous job, the
+	// component with offset 0 leads the others.
+	hetJobID  string
+	hetOffset int
+	// expectedStart is when the scheduler expects a pending job to start,
+	// as a Unix timestamp, 0 if it has no estimate
+	expectedStart int64
+}
+
+// tasks returns the number of jobs a job stands for, which is more than one
+// for the pending tasks of an array.
+func (j Job) tasks() float64 {
+	if j.arrayTasks > 0 {
+		return j.arrayTasks
+	}
+	return 1
+}
+
+// count returns the number of jobs a job adds to the job counts. The
+// components of a heterogeneous job are counted once, by their leader.
+func (j Job) count() float64 {
+	if j.hetOffset > 0 {
+		return 0
+	}
+	return j.tasks()
+}
+
+// countArrayTasks counts the tasks of an array index expression, e.g. 5 for
+// "0-3,7" or 10 for "0-9%2" where %2 limits how many run at once. Steps are
+// given with a colon, e.g. "0-9:2".
+func countArrayTasks(indexes string) float64 {
+	indexes, _, _ = strings.Cut(indexes, "%")
+	var count float64
+	for _, single := range strings.Split(indexes, ",") {
+		first, last, isRange := strings.Cut(single, "-")
+		if !isRange {
+			if _, err := strconv.Atoi(first); err == nil {
+				count++
+			}
+			continue
+		}
+		last, step, _ := strings.Cut(last, ":")
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil || to < from {
+			continue
+		}
+		by := 1
+		if step != "" {
+			if by, err = strconv.Atoi(step); err != nil || by < 1 {
+				continue
+			}
+		}
+		count += float64((to-from)/by + 1)
+	}
+	return count
+}
+
+// ParseJobs parses the output of squeue formatted with squeueFormat, run
+// without -r so that the pending tasks of an array are not expanded.
+func ParseJobs(input []byte) []Job {
+	return parseJobs(input, time.Local)
+}
+
+// parseJobs reads the expected start times in loc.
+func parseJobs(input []byte, loc *time.Location) []Job {
+	var jobs []Job
+	for _, line := range SplitLines(input) {
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if len(parts) < 8 {
+			continue
+		}
+		cpus, _ := strconv.ParseFloat(parts[5], 64)
+		job := Job{
+			id:        parts[0],
+			user:      parts[1],
+			account:   parts[2],
+			partition: parts[3],
+			state:     parts[4],
+			cpus:      cpus,
+			memory:    ParseMemory(parts[6]),
+			reason:    parts[7],
+		}
+		if len(parts) >= 13 {
+			job.qos = parts[8]
+			job.timeUsed, _ = ParseElapsedTime(parts[9])
+			// the time left is UNLIMITED, NOT_SET or INVALID without a limit
+			if left, err := ParseElapsedTime(parts[10]); err == nil {
+				job.timeLeft = left
+				job.timeLimited = true
+			}
+			nodes, _ := strconv.ParseFloat(parts[12], 64)
+			job.gpus = jobGPUs(parts[11], nodes)
+		}
+		if len(parts) >= 14 {
+			job.submit, _ = parseSlurmTime(parts[13], loc)
+		}
+		// %K is N/A for jobs which are not part of an array
+		if len(parts) >= 16 && parts[15] != "N/A" {
+			job.arrayJobID = parts[14]
+			if strings.ContainsAny(parts[15], ",-") {
+				job.arrayTasks = countArrayTasks(parts[15])
+			}
+		}
+		// %i is 123+1 for the second component of heterogeneous job 123
+		if len(parts) >= 17 {
+			if hetJobID, offset, isHet := strings.Cut(parts[16], "+"); isHet {
+				job.hetJobID = hetJobID
+				job.hetOffset, _ = strconv.Atoi(offset)
+			}
+		}
+		// %S is when a job started, or is expected to start if pending,
+		// N/A without an estimate
+		if len(parts) >= 18 && job.state == "PENDING" {
+			job.expectedStart, _ = parseSlurmTime(parts[17], loc)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// jobGPUs returns the GPUs of a job spanning the given number of nodes, from
+// the generic resources it requested per node, e.g. gres/gpu:a100:2.
+func jobGPUs(tresPerNode string, nodes float64) float64 {
+	var gpus float64
+	for _, single := range strings.Split(tresPerNode, ",") {
+		single = strings.TrimPrefix(strings.TrimPrefix(single, "gres/"), "gres:")
+		if !strings.HasPrefix(single, "gpu:") {
+			continue
+		}
+		for _, gres := range ParseGenericResources(single) {
+			gpus += gres.count
+		}
+	}
+	if nodes < 1 {
+		nodes = 1
+	}
+	return gpus * nodes
+}
+
+// Node is a single node as reported by sinfo, together with all the
+// partitions it belongs to.
+type Node struct {
+	name       string
+	partitions []string
+	memAlloc   float64
+	memTotal   float64
+	cpu        CPUs
+	gres       []GenericResource
+	gresUsed   []GenericResource
+	nodeStatus string
+	// features are the features of the node in slurm.conf, activeFeatures
+	// those currently active, which differ for changeable features
+	features       []string
+	activeFeatures []string
+	// state is the base state of the node, e.g. idle, and flags the known
+	// nodeFlags it has, sorted
+	state string
+	flags []string
+}
+
+// nodeFlags maps the flags of a node state kept apart from its base state,
+// as named by Slurm, to the flag they are exported as.
+var nodeFlags = map[string]string{
+	"drain":            "drain",
+	"completing":       "completing",
+	"not_responding":   "not_responding",
+	"powered_down":     "powered_down",
+	"powering_up":      "powering_up",
+	"reboot_requested": "reboot_requested",
+	"maintenance":      "maint",
+	"reserved":         "reserved",
+	"cloud":            "cloud",
+	"fail":             "fail",
+}
+
+// parseNodeState splits a full node state, e.g. idle+drain+not_responding
+// as printed with StateComplete, into its base state and known flags.
+func parseNodeState(states []string) (string, []string) {
+	if len(states) == 0 {
+		return "", nil
+	}
+	var flags []string
+	for _, flag := range states[1:] {
+		if known, ok := nodeFlags[strings.ToLower(flag)]; ok {
+			flags = append(flags, known)
+		}
+	}
+	sort.Strings(flags)
+	return strings.ToLower(states[0]), flags
+}
+
+// nodeStateSymbols maps the symbols sinfo appends to StateLong to the
+// flags they stand for, e.g. down* for a node which is not responding.
+var nodeStateSymbols = map[rune]string{
+	'*': "not_responding",
+	'~': "powered_down",
+	'#': "powering_up",
+	'$': "maint",
+	'@': "reboot_requested",
+}
+
+// parseNodeStateLong approximates the base state and flags of a node from
+// StateLong, for the versions of sinfo without StateComplete. The states
+// which stand for a flag, e.g. drained or draining, take their base state
+// from the allocated CPUs.
+func parseNodeStateLong(stateLong string, cpu CPUs) (string, []string) {
+	state := strings.TrimRight(stateLong, "*~#!%$@^-")
+	var flags []string
+	for _, symbol := range stateLong[len(state):] {
+		if flag, ok := nodeStateSymbols[symbol]; ok {
+			flags = append(flags, flag)
+		}
+	}
+
+	base := "idle"
+	if cpu.alloc > 0 && cpu.alloc >= cpu.total {
+		base = "allocated"
+	} else if cpu.alloc > 0 {
+		base = "mixed"
+	}
+	switch state {
+	case "drained", "draining", "drain":
+		state, flags = base, append(flags, "drain")
+	case "completing":
+		state, flags = base, append(flags, "completing")
+	case "maint":
+		state, flags = base, append(flags, "maint")
+	case "reserved":
+		state, flags = base, append(flags, "reserved")
+	case "fail", "failing":
+		// a failing node is drained as well
+		state, flags = base, append(flags, "drain", "fail")
+	}
+	sort.Strings(flags)
+	return state, flags
+}
+
+// parseList splits the comma separated lists printed by Slurm, such as the
+// features of a node, which are (null) if empty.
+func parseList(lists ...string) []string {
+	var features []string
+	for _, list := range lists {
+		for _, feature := range strings.Split(list, ",") {
+			if feature = strings.TrimSpace(feature); feature != "" && feature != "(null)" {
+				features = append(features, feature)
+			}
+		}
+	}
+	return features
+}
+
+// ParseNodes parses the output of sinfo formatted with sinfoFormat. sinfo
+// lists a node once for every partition it belongs to, those lines are
+// merged into a single Node. The result is sorted by node name.
+func ParseNodes(input []byte) []Node {
+	nodes := make(map[string]*Node)
+	for _, line := range SplitLines(input) {
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if len(parts) < 8 {
+			continue
+		}
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+
+		name := parts[0]
+		if node, ok := nodes[name]; ok {
+			node.partitions = append(node.partitions, parts[1])
+			continue
+		}
+
+		node := &Node{name: name, partitions: []string{parts[1]}, nodeStatus: parts[5]}
+		node.memAlloc, _ = strconv.ParseFloat(parts[2], 64)
+		node.memTotal, _ = strconv.ParseFloat(parts[3], 64)
+		node.cpu = ParseCPUs(parts[4])
+		if parts[6] != "(null)" && len(parts[6]) > 0 {
+			node.gres = ParseGenericResources(parts[6])
+			node.gresUsed = ParseGenericResources(parts[7])
+		}
+		if len(parts) >= 10 {
+			node.features = parseList(parts[8])
+			node.activeFeatures = parseList(parts[9])
+		}
+		if len(parts) >= 11 {
+			node.state, node.flags = parseNodeState(strings.Split(parts[10], "+"))
+		} else {
+			node.state, node.flags = parseNodeStateLong(parts[5], node.cpu)
+		}
+		nodes[name] = node
+	}
+
+	result := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		result = append(result, *node)
+	}
+	sortNodes(result)
+	return result
+}
+
+func sortNodes(nodes []Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+}
+
+// snapshot holds the Slurm datasets fetched during a single scrape. Each
+// dataset is fetched at most once, no matter how many collectors use it.
+type snapshot struct {
+	// ctx bounds the fetches, it lives as long as the whole scrape, so that
+	// a collector with a short timeout does not abort a shared fetch.
+	ctx     context.Context
+	mtx     sync.Mutex
+	entries map[string]*snapshotEntry
+}
+
+type snapshotEntry struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+type snapshotKey struct{}
+
+func newSnapshot(ctx context.Context) *snapshot {
+	return &snapshot{ctx: ctx, entries: make(map[string]*snapshotEntry)}
+}
+
+// withSnapshot returns a context carrying the given snapshot.
+func withSnapshot(ctx context.Context, s *snapshot) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, s)
+}
+
+// snapshotFromContext returns the snapshot of the current scrape, or nil.
+func snapshotFromContext(ctx context.Context) *snapshot {
+	s, _ := ctx.Value(snapshotKey{}).(*snapshot)
+	return s
+}
+
+// fetch returns the dataset stored under key, calling fn to load it if no
+// other collector did so yet. Without a snapshot fn is called directly.
+func (s *snapshot) fetch(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if s == nil {
+		return fn(ctx)
+	}
+
+	s.mtx.Lock()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &snapshotEntry{done: make(chan struct{})}
+		s.entries[key] = entry
+		go func() {
+			entry.value, entry.err = fn(s.ctx)
+			close(entry.done)
+		}()
+	}
+	s.mtx.Unlock()
+
+	select {
+	case <-entry.done:
+		return entry.value, entry.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for %s: %w", key, ctx.Err())
+	}
+}
+
+// sharedClient shares the datasets of the wrapped client between the
+// collectors of a scrape, through the snapshot stored in the context.
+type sharedClient struct {
+	SlurmClient
+	// prefix separates the datasets of clients which differ from the
+	// default one, e.g. by the path of the commands
+	prefix string
+	// cluster is the name of the cluster the client reads, empty for the
+	// cluster the exporter runs in
+	cluster string
+}
+
+// clusterOf returns the name of the cluster a collector's client reads.
+func clusterOf(client SlurmClient) string {
+	if shared, ok := client.(sharedClient); ok {
+		return shared.cluster
+	}
+	return ""
+}
+
+func (c sharedClient) Jobs(ctx context.Context) ([]Job, error) {
+	value, err := snapshotFromContext(ctx).fetch(ctx, c.prefix+"jobs", func(ctx context.Context) (interface{}, error) {
+		return c.SlurmClient.Jobs(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]Job), nil
+}
+
+func (c sharedClient) Nodes(ctx context.Context) ([]Node, error) {
+	value, err := snapshotFromContext(ctx).fetch(ctx, c.prefix+"nodes", func(ctx context.Context) (interface{}, error) {
+		return c.SlurmClient.Nodes(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]Node), nil
+}
+
+func (c sharedClient) NodeDetails(ctx context.Context) ([]NodeDetails, error) {
+	value, err := snapshotFromContext(ctx).fetch(ctx, c.prefix+"nodeDetails", func(ctx context.Context) (interface{}, error) {
+		return c.SlurmClient.NodeDetails(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]NodeDetails), nil
+}
+
+func (c sharedClient) Diag(ctx context.Context) (*SchedulerMetrics, error) {
+	value, err := snapshotFromContext(ctx).fetch(ctx, c.prefix+"diag", func(ctx context.Context) (interface{}, error) {
+		return c.SlurmClient.Diag(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*SchedulerMetrics), nil
+}
+
+func (c sharedClient) Shares(ctx context.Context) (map[string]*FairShareMetrics, error) {
+	value, err := snapshotFromContext(ctx).fetch(ctx, c.prefix+"shares", func(ctx context.Context) (interface{}, error) {
+		return c.SlurmClient.Shares(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]*FairShareMetrics), nil
+}
@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJobs(t *testing.T) {
+	jobs := ParseJobs([]byte("93348|user2|ampere|gpu|PENDING|4|32G|Priority\n\nbroken line\n"))
+
+	assert.Equal(t, []Job{{
+		id:        "93348",
+		user:      "user2",
+		account:   "ampere",
+		partition: "gpu",
+		state:     "PENDING",
+		cpus:      4,
+		memory:    32 * 1024 * 1024 * 1024,
+		reason:    "Priority",
+	}}, jobs)
+}
+
+func TestParseJobsArray(t *testing.T) {
+	jobs := ParseJobs([]byte("96000|user1|account1|cpu|PENDING|2|4G|JobArrayTaskLimit|normal|0:00|1:00:00|N/A|1|2024-06-19T06:00:00|96000|4-9%4\n" +
+		"96001|user1|account1|cpu|RUNNING|2|4G|None|normal|10:00|50:00|N/A|1|2024-06-19T06:00:00|96000|0\n" +
+		"96030|user3|account3|cpu|PENDING|1|1G|Priority|normal|0:00|10:00|N/A|1|2024-06-19T08:00:00|96030|N/A\n"))
+
+	assert.Len(t, jobs, 3)
+	assert.Equal(t, "96000", jobs[0].arrayJobID)
+	assert.Equal(t, 6.0, jobs[0].tasks())
+	assert.Equal(t, "96000", jobs[1].arrayJobID)
+	assert.Equal(t, 1.0, jobs[1].tasks())
+	assert.Equal(t, "", jobs[2].arrayJobID)
+	assert.Equal(t, 1.0, jobs[2].tasks())
+}
+
+func TestCountArrayTasks(t *testing.T) {
+	for input, expected := range map[string]float64{
+		"7":        1,
+		"0-3,7":    5,
+		"0-9%2":    10,
+		"0-9:2":    5,
+		"0-7:2,11": 5,
+		"1-3,5-6":  5,
+		"N/A":      0,
+	} {
+		assert.Equal(t, expected, countArrayTasks(input), input)
+	}
+}
+
+func TestSnapshotFetchOnce(t *testing.T) {
+	ctx := context.Background()
+	s := newSnapshot(ctx)
+
+	var calls int32
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return "data", nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := s.fetch(ctx, "jobs", load)
+			assert.NoError(t, err)
+			assert.Equal(t, "data", value)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestSnapshotFetchTimeout(t *testing.T) {
+	s := newSnapshot(context.Background())
+	release := make(chan struct{})
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := s.fetch(ctx, "nodes", func(ctx context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	assert.True(t, IsTimeoutError(err))
+}
@@ -18,6 +18,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
+	"context"
 	"strconv"
 	"strings"
 
@@ -51,6 +52,7 @@ func ParseFairShareMetrics(input []byte) map[string]*FairShareMetrics {
 
 type FairShareCollector struct {
 	fairshare *prometheus.Desc
+	client    SlurmClient
 	logger    log.Logger
 }
 
@@ -58,20 +60,20 @@ func init() {
 	registerCollector("fairshare", defaultDisabled, NewFairShareCollector)
 }
 
-func NewFairShareCollector(logger log.Logger) (Collector, error) {
+func NewFairShareCollector(logger log.Logger, client SlurmClient) (Collector, error) {
 	return &FairShareCollector{
+		client:    client,
 		logger:    logger,
 		fairshare: prometheus.NewDesc("slurm_account_fairshare", "FairShare for account", []string{"account"}, nil),
 	}, nil
 }
 
-func (fsc *FairShareCollector) Collect(ch chan<- prometheus.Metric) error {
-	out, err := RunCommand("sshare", "-n", "-P", "-o", "account,fairshare")
+func (fsc *FairShareCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	fsm, err := fsc.client.Shares(ctx)
 	if err != nil {
 		return err
 	}
 
-	fsm := ParseFairShareMetrics(out)
 	for f := range fsm {
 		ch <- prometheus.MustNewConstMetric(fsc.fairshare, prometheus.GaugeValue, fsm[f].fairshare, f)
 	}
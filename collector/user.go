@@ -18,9 +18,9 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package collector
 
 import (
+	"context"
 	"regexp"
 	"strconv"
-	"strings"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -35,62 +35,52 @@ type UserJobMetrics struct {
 	jobsSuspended float64
 }
 
+// memoryPattern matches a memory size printed by Slurm, sacct adds a suffix
+// for the memory requested per node (n) or per CPU (c).
+var memoryPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)([KMGTP]?)([nc]?)$`)
+
+// ParseMemory parses a memory size, e.g. 32G, 4.50G or 4000Mn, into bytes.
+// A size without unit is in bytes, an invalid one is 0.
 func ParseMemory(input string) float64 {
-	if len(input) == 0 || input == "0" {
+	matches := memoryPattern.FindStringSubmatch(input)
+	if matches == nil {
 		return 0
 	}
-
-	rMemory := regexp.MustCompile(`^(\d+)([KMGT])$`)
-	matches := rMemory.FindStringSubmatch(input)
-	num, _ := strconv.Atoi(matches[1])
-	unit := matches[2]
-	ret := 0
-	if "K" == unit {
-		ret = num * 1024
-	} else if "M" == unit {
-		ret = num * 1024 * 1024
-	} else if "G" == unit {
-		ret = num * 1024 * 1024 * 1024
-	} else if "T" == unit {
-		ret = num * 1024 * 1024 * 1024 * 1024
-	} else {
-		ret = num
+	num, _ := strconv.ParseFloat(matches[1], 64)
+	switch matches[2] {
+	case "K":
+		num *= 1024
+	case "M":
+		num *= 1024 * 1024
+	case "G":
+		num *= 1024 * 1024 * 1024
+	case "T":
+		num *= 1024 * 1024 * 1024 * 1024
+	case "P":
+		num *= 1024 * 1024 * 1024 * 1024 * 1024
 	}
-	return float64(ret)
+	return num
 }
 
-func ParseUserMetrics(input []byte) map[string]*UserJobMetrics {
+func ParseUserMetrics(jobs []Job) map[string]*UserJobMetrics {
 	users := make(map[string]*UserJobMetrics)
 
-	var (
-		pending   = regexp.MustCompile(`^pending`)
-		running   = regexp.MustCompile(`^running`)
-		suspended = regexp.MustCompile(`^suspended`)
-	)
-
-	for _, line := range SplitLines(input) {
-		if strings.Contains(line, "|") {
-			parts := strings.Split(line, "|")
-			user := parts[1]
-			_, key := users[user]
-			if !key {
-				users[user] = &UserJobMetrics{}
-			}
-			state := parts[2]
-			state = strings.ToLower(state)
-			cpus, _ := strconv.ParseFloat(parts[3], 64)
-			mem := ParseMemory(parts[4])
-			switch {
-			case pending.MatchString(state) == true:
-				users[user].jobsPending++
-				users[user].cpusPending += cpus
-			case running.MatchString(state) == true:
-				users[user].jobsRunning++
-				users[user].cpusRunning += cpus
-				users[user].memRunning += mem
-			case suspended.MatchString(state) == true:
-				users[user].jobsSuspended++
-			}
+	for _, job := range jobs {
+		_, key := users[job.user]
+		if !key {
+			users[job.user] = &UserJobMetrics{}
+		}
+
+		switch job.state {
+		case "PENDING":
+			users[job.user].jobsPending += job.count()
+			users[job.user].cpusPending += job.cpus * job.tasks()
+		case "RUNNING":
+			users[job.user].jobsRunning += job.count()
+			users[job.user].cpusRunning += job.cpus
+			users[job.user].memRunning += job.memory
+		case "SUSPENDED":
+			users[job.user].jobsSuspended += job.count()
 		}
 	}
 	return users
@@ -103,6 +93,7 @@ type UserCollector struct {
 	cpusRunning   *prometheus.Desc
 	memRunning    *prometheus.Desc
 	jobsSuspended *prometheus.Desc
+	client        SlurmClient
 	logger        log.Logger
 }
 
@@ -110,8 +101,9 @@ func init() {
 	registerCollector("user", defaultEnabled, NewUserCollector)
 }
 
-func NewUserCollector(logger log.Logger) (Collector, error) {
+func NewUserCollector(logger log.Logger, client SlurmClient) (Collector, error) {
 	return &UserCollector{
+		client:        client,
 		logger:        logger,
 		jobsPending:   prometheus.NewDesc("slurm_user_jobs_pending", "Pending jobs for user", []string{"user"}, nil),
 		cpusPending:   prometheus.NewDesc("slurm_user_cpus_pending", "Pending jobs for user", []string{"user"}, nil),
@@ -122,13 +114,13 @@ func NewUserCollector(logger log.Logger) (Collector, error) {
 	}, nil
 }
 
-func (uc *UserCollector) Collect(ch chan<- prometheus.Metric) error {
-	out, err := RunCommand("squeue", "-a", "-r", "-h", "-o %A|%u|%T|%C|%m")
+func (uc *UserCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	jobs, err := uc.client.Jobs(ctx)
 	if err != nil {
 		return err
 	}
 
-	um := ParseUserMetrics(out)
+	um := ParseUserMetrics(jobs)
 	for u := range um {
 		if um[u].jobsPending > 0 {
 			ch <- prometheus.MustNewConstMetric(uc.jobsPending, prometheus.GaugeValue, um[u].jobsPending, u)
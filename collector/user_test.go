@@ -11,7 +11,7 @@ func TestParseUsersMetrics(t *testing.T) {
 	// Read the input data from a file
 	file, _ := os.Open("fixtures/squeue/user.txt")
 	data, _ := io.ReadAll(file)
-	users := ParseUserMetrics(data)
+	users := ParseUserMetrics(ParseJobs(data))
 
 	assert.Equal(t, 31.0, users["user2"].jobsPending, "Miscount of pending user jobs")
 	assert.Equal(t, 8.0, users["user2"].jobsRunning, "Miscount of running user jobs")
@@ -20,3 +20,30 @@ func TestParseUsersMetrics(t *testing.T) {
 	assert.Equal(t, 124.0, users["user2"].cpusPending, "Miscount of pending user CPUs")
 	assert.Equal(t, 2.74877906944e+11, users["user2"].memRunning, "Miscount of running user Memory")
 }
+
+func TestParseUsersMetricsArrays(t *testing.T) {
+	data, err := os.ReadFile("fixtures/squeue/array.txt")
+	assert.NoError(t, err)
+	users := ParseUserMetrics(ParseJobs(data))
+
+	assert.Equal(t, 6.0, users["user1"].jobsPending, "Miscount of pending array tasks")
+	assert.Equal(t, 12.0, users["user1"].cpusPending, "Miscount of pending array CPUs")
+	assert.Equal(t, 4.0, users["user1"].jobsRunning, "Miscount of running array tasks")
+	assert.Equal(t, 6.0, users["user2"].jobsPending, "Miscount of pending array tasks")
+}
+
+func TestParseMemory(t *testing.T) {
+	for input, expected := range map[string]float64{
+		"0":       0,
+		"":        0,
+		"Unknown": 0,
+		"1024":    1024,
+		"32G":     32 * 1024 * 1024 * 1024,
+		"4.50G":   4.5 * 1024 * 1024 * 1024,
+		"1234K":   1234 * 1024,
+		"4000Mn":  4000 * 1024 * 1024,
+		"2Gc":     2 * 1024 * 1024 * 1024,
+	} {
+		assert.Equal(t, expected, ParseMemory(input), input)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+	Copyright 2024 Oleh Astappiev
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// sacctStartedFormat lists the fields of the started jobs read from sacct.
+const sacctStartedFormat = "JobID,Partition,QOS,Submit,Start"
+
+// StartedJob is a job which started running, as reported by sacct.
+type StartedJob struct {
+	id        string
+	partition string
+	qos       string
+	// submit and start are Unix timestamps
+	submit int64
+	start  int64
+}
+
+// ParseStartedJobs parses the output of sacct -P formatted with
+// sacctStartedFormat. Lines without valid times are skipped.
+func ParseStartedJobs(input []byte) []StartedJob {
+	return parseStartedJobs(input, time.Local)
+}
+
+// parseStartedJobs reads the Submit and Start times in loc.
+func parseStartedJobs(input []byte, loc *time.Location) []StartedJob {
+	var jobs []StartedJob
+	for _, line := range SplitLines(input) {
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if len(parts) < 5 {
+			continue
+		}
+		submit, err := parseSlurmTime(parts[3], loc)
+		if err != nil {
+			continue
+		}
+		start, err := parseSlurmTime(parts[4], loc)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, StartedJob{id: parts[0], partition: parts[1], qos: parts[2], submit: submit, start: start})
+	}
+	return jobs
+}
+
+// OldestPending returns the age of the oldest pending job per partition,
+// for the jobs whose submit time is known.
+func OldestPending(jobs []Job, now time.Time) map[string]float64 {
+	oldest := make(map[string]float64)
+	for _, job := range jobs {
+		if job.state != "PENDING" || job.submit == 0 {
+			continue
+		}
+		age := float64(now.Unix() - job.submit)
+		if age > oldest[job.partition] {
+			oldest[job.partition] = age
+		}
+	}
+	return oldest
+}
+
+// waitTimeOptions are the options of the wait_time collector in the
+// configuration file.
+type waitTimeOptions struct {
+	// Buckets are the upper bounds of the wait time histogram, in seconds
+	Buckets      []float64 `yaml:"buckets"`
+	sacctOptions `yaml:",inline"`
+}
+
+func (o *waitTimeOptions) validate() error {
+	return checkBuckets("wait_time", o.Buckets)
+}
+
+func defaultWaitTimeOptions() interface{} {
+	return &waitTimeOptions{
+		Buckets:      []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400, 172800, 604800},
+		sacctOptions: sacctOptions{Lookback: model.Duration(time.Hour)},
+	}
+}
+
+// waitKey are the labels the wait times are observed by.
+type waitKey struct {
+	partition, qos string
+}
+
+// histogram accumulates the observations of a constant histogram, counts
+// holds them per bucket, not cumulated.
+type histogram struct {
+	count  uint64
+	sum    float64
+	counts []uint64
+}
+
+func (h *histogram) observe(buckets []float64, value float64) {
+	h.observeCount(buckets, value, 1)
+}
+
+// observeCount observes the same value count times.
+func (h *histogram) observeCount(buckets []float64, value float64, count uint64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+	h.count += count
+	h.sum += value * float64(count)
+	if i := sort.SearchFloat64s(buckets, value); i < len(buckets) {
+		h.counts[i] += count
+	}
+}
+
+// checkBuckets returns an error if the buckets of a collector are not in
+// increasing order.
+func checkBuckets(collector string, buckets []float64) error {
+	for i := range buckets {
+		if i > 0 && buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("%s buckets must be in increasing order: %v", collector, buckets)
+		}
+	}
+	return nil
+}
+
+func (h *histogram) metric(desc *prometheus.Desc, buckets []float64, labels ...string) prometheus.Metric {
+	cumulated := make(map[float64]uint64, len(buckets))
+	var cumulative uint64
+	for i, bound := range buckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		cumulated[bound] = cumulative
+	}
+	return prometheus.MustNewConstHistogram(desc, h.count, h.sum, cumulated, labels...)
+}
+
+type WaitTimeCollector struct {
+	waitTime      *prometheus.Desc
+	oldestPending *prometheus.Desc
+	buckets       []float64
+	client        SlurmClient
+	logger        log.Logger
+
+	sacctWindow
+	histograms map[waitKey]*histogram
+}
+
+func init() {
+	registerCollector("wait_time", defaultDisabled, NewWaitTimeCollector)
+	registerCollectorOptions("wait_time", defaultWaitTimeOptions)
+}
+
+func NewWaitTimeCollector(logger log.Logger, client SlurmClient) (Collector, error) {
+	options := defaultWaitTimeOptions().(*waitTimeOptions)
+	if err := decodeOptions("wait_time", options); err != nil {
+		return nil, err
+	}
+
+	return &WaitTimeCollector{
+		client:        client,
+		logger:        logger,
+		buckets:       options.Buckets,
+		sacctWindow:   sacctWindow{lookback: time.Duration(options.Lookback), cursor: newJobCursor()},
+		histograms:    make(map[waitKey]*histogram),
+		waitTime:      prometheus.NewDesc("slurm_job_wait_seconds", "Time the started jobs waited between their submission and their start", []string{"partition", "qos"}, nil),
+		oldestPending: prometheus.NewDesc("slurm_partition_oldest_pending_job_seconds", "Age of the oldest pending job of the partition", []string{"partition"}, nil),
+	}, nil
+}
+
+func (wc *WaitTimeCollector) Collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	wc.mtx.Lock()
+	defer wc.mtx.Unlock()
+
+	now := time.Now()
+	jobs, err := wc.client.Jobs(ctx)
+	if err != nil {
+		return err
+	}
+	if err := wc.update(ctx, now); err != nil {
+		return err
+	}
+
+	for key, h := range wc.histograms {
+		ch <- h.metric(wc.waitTime, wc.buckets, key.partition, key.qos)
+	}
+	for partition, age := range OldestPending(jobs, now) {
+		ch <- prometheus.MustNewConstMetric(wc.oldestPending, prometheus.GaugeValue, age, partition)
+	}
+	return nil
+}
+
+// update observes the wait time of the jobs which started since the cursor
+// and moves it.
+func (wc *WaitTimeCollector) update(ctx context.Context, now time.Time) error {
+	return wc.advance(now, func(start, end time.Time, isNew func(string, int64) bool) error {
+		jobs, err := wc.client.StartedJobs(ctx, start, end)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if !isNew(job.id, job.start) {
+				continue
+			}
+			wait := float64(job.start - job.submit)
+			if wait < 0 {
+				wait = 0
+			}
+
+			key := waitKey{partition: job.partition, qos: job.qos}
+			h, ok := wc.histograms[key]
+			if !ok {
+				h = &histogram{}
+				wc.histograms[key] = h
+			}
+			h.observe(wc.buckets, wait)
+		}
+		return nil
+	})
+}
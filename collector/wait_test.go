@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStartedJobs(t *testing.T) {
+	// the fixture was recorded in UTC
+	jobs := parseStartedJobs(readFixture(t, "fixtures/sacct/started.txt"), time.UTC)
+
+	require.Len(t, jobs, 6)
+	assert.Equal(t, StartedJob{
+		id:        "95001",
+		partition: "gpu",
+		qos:       "normal",
+		submit:    time.Date(2024, 6, 19, 8, 0, 0, 0, time.UTC).Unix(),
+		start:     time.Date(2024, 6, 19, 8, 0, 30, 0, time.UTC).Unix(),
+	}, jobs[0])
+
+	fromJSON, err := ParseStartedJobsJSON(readFixture(t, "fixtures/sacct/slurm-23.11.4/started.json"))
+	require.NoError(t, err)
+	assert.Equal(t, jobs, fromJSON)
+}
+
+func TestOldestPending(t *testing.T) {
+	jobs := ParseJobs(readFixture(t, "fixtures/squeue/pending.txt"))
+	now := time.Date(2024, 6, 19, 10, 0, 0, 0, time.Local)
+
+	assert.Equal(t, map[string]float64{"gpu": 4 * 3600, "cpu": 22 * 3600}, OldestPending(jobs, now))
+}
+
+func TestWaitTimeCollector(t *testing.T) {
+	require.NoError(t, loadTestConfig(t, "collectors:\n  wait_time:\n    options:\n      buckets: [60, 3600, 86400]\n"))
+	c, err := NewWaitTimeCollector(log.NewNopLogger(), fixtureClient{jobs: "fixtures/squeue/pending.txt", startedJobs: "fixtures/sacct/started.txt"})
+	require.NoError(t, err)
+	wc := c.(*WaitTimeCollector)
+	now := time.Date(2024, 6, 19, 9, 0, 0, 0, time.Local)
+	require.NoError(t, wc.update(context.Background(), now))
+	// sacct lists the jobs within the overlap again
+	require.NoError(t, wc.update(context.Background(), now.Add(time.Minute)))
+
+	expected := `
+# HELP slurm_job_wait_seconds Time the started jobs waited between their submission and their start
+# TYPE slurm_job_wait_seconds histogram
+slurm_job_wait_seconds_bucket{partition="cpu",qos="long",le="60"} 0
+slurm_job_wait_seconds_bucket{partition="cpu",qos="long",le="3600"} 0
+slurm_job_wait_seconds_bucket{partition="cpu",qos="long",le="86400"} 0
+slurm_job_wait_seconds_bucket{partition="cpu",qos="long",le="+Inf"} 1
+slurm_job_wait_seconds_sum{partition="cpu",qos="long"} 174600
+slurm_job_wait_seconds_count{partition="cpu",qos="long"} 1
+slurm_job_wait_seconds_bucket{partition="cpu",qos="normal",le="60"} 0
+slurm_job_wait_seconds_bucket{partition="cpu",qos="normal",le="3600"} 0
+slurm_job_wait_seconds_bucket{partition="cpu",qos="normal",le="86400"} 2
+slurm_job_wait_seconds_bucket{partition="cpu",qos="normal",le="+Inf"} 2
+slurm_job_wait_seconds_sum{partition="cpu",qos="normal"} 17100
+slurm_job_wait_seconds_count{partition="cpu",qos="normal"} 2
+slurm_job_wait_seconds_bucket{partition="gpu",qos="high",le="60"} 1
+slurm_job_wait_seconds_bucket{partition="gpu",qos="high",le="3600"} 1
+slurm_job_wait_seconds_bucket{partition="gpu",qos="high",le="86400"} 1
+slurm_job_wait_seconds_bucket{partition="gpu",qos="high",le="+Inf"} 1
+slurm_job_wait_seconds_sum{partition="gpu",qos="high"} 60
+slurm_job_wait_seconds_count{partition="gpu",qos="high"} 1
+slurm_job_wait_seconds_bucket{partition="gpu",qos="normal",le="60"} 1
+slurm_job_wait_seconds_bucket{partition="gpu",qos="normal",le="3600"} 1
+slurm_job_wait_seconds_bucket{partition="gpu",qos="normal",le="86400"} 2
+slurm_job_wait_seconds_bucket{partition="gpu",qos="normal",le="+Inf"} 2
+slurm_job_wait_seconds_sum{partition="gpu",qos="normal"} 4230
+slurm_job_wait_seconds_count{partition="gpu",qos="normal"} 2
+`
+	rc := &registryCollector{Collector: wc}
+	assert.NoError(t, testutil.CollectAndCompare(rc, strings.NewReader(expected), "slurm_job_wait_seconds"))
+	assert.NoError(t, rc.err)
+	assert.Equal(t, 2, testutil.CollectAndCount(rc, "slurm_partition_oldest_pending_job_seconds"))
+}
+
+func TestWaitTimeCollectorBuckets(t *testing.T) {
+	err := loadTestConfig(t, "collectors:\n  wait_time:\n    options:\n      buckets: [60, 60, 30]\n")
+	assert.ErrorContains(t, err, "increasing order")
+}
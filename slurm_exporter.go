@@ -20,7 +20,10 @@ import (
 	stdlog "log"
 	"net/http"
 	"os"
+	"os/signal"
 	"sort"
+	"sync"
+	"syscall"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/kit/log/level"
@@ -42,6 +45,7 @@ var (
 	metricsPath            = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
 	disableExporterMetrics = kingpin.Flag("web.disable-exporter-metrics", "Exclude metrics about the exporter itself (promhttp_*, process_*, go_*).").Bool()
 	maxRequests            = kingpin.Flag("web.max-requests", "Maximum number of parallel scrape requests. Use 0 to disable.").Default("40").Int()
+	configFile             = kingpin.Flag("config.file", "Path to the YAML configuration file of the collectors, it is reloaded on SIGHUP.").String()
 	toolkitFlags           = kingpinflag.AddFlags(kingpin.CommandLine, ":9341")
 )
 
@@ -49,6 +53,7 @@ var (
 // created on the fly, if filtering is requested. Create instances with
 // newHandler.
 type handler struct {
+	mtx               sync.RWMutex
 	unfilteredHandler http.Handler
 	// exporterMetricsRegistry is a separate registry for the metrics about
 	// the exporter itself.
@@ -65,7 +70,10 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if len(filters) == 0 {
 		// No filters, use the prepared unfiltered handler.
-		h.unfilteredHandler.ServeHTTP(w, r)
+		h.mtx.RLock()
+		unfilteredHandler := h.unfilteredHandler
+		h.mtx.RUnlock()
+		unfilteredHandler.ServeHTTP(w, r)
 		return
 	}
 	// To serve filtered metrics, we create a filtering handler on the fly.
@@ -100,13 +108,28 @@ func newHandler(includeExporterMetrics bool, maxRequests int, logger log.Logger)
 	return h
 }
 
+// reload loads the configuration file again and replaces the unfiltered
+// handler, the current one is kept if the configuration is invalid.
+func (h *handler) reload(path string) error {
+	return collector.ReloadConfig(path, func() error {
+		innerHandler, err := h.innerHandler()
+		if err != nil {
+			return err
+		}
+		h.mtx.Lock()
+		h.unfilteredHandler = innerHandler
+		h.mtx.Unlock()
+		return nil
+	})
+}
+
 // innerHandler is used to create both the one unfiltered http.Handler to be
 // wrapped by the outer handler and also the filtered handlers created on the
 // fly. The former is accomplished by calling innerHandler without any arguments
 // (in which case it will log all the collectors enabled via command-line
 // flags).
 func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
-	nc, err := collector.NewSlurmCollector(h.logger, filters...)
+	ncs, err := collector.NewSlurmCollectors(h.logger, filters...)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't create collector: %s", err)
 	}
@@ -116,8 +139,11 @@ func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
 	if len(filters) == 0 {
 		level.Info(h.logger).Log("msg", "Enabled collectors")
 		var collectors []string
-		for n := range nc.Collectors {
-			collectors = append(collectors, n)
+		for _, nc := range ncs {
+			for n := range nc.Collectors {
+				collectors = append(collectors, n)
+			}
+			break
 		}
 		sort.Strings(collectors)
 		for _, c := range collectors {
@@ -127,8 +153,15 @@ func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
 
 	r := prometheus.NewRegistry()
 	r.MustRegister(versioncollector.NewCollector("slurm_exporter"))
-	if err := r.Register(nc); err != nil {
-		return nil, fmt.Errorf("couldn't register node collector: %s", err)
+	for cluster, nc := range ncs {
+		// with several clusters, every metric is labelled with its cluster
+		var registerer prometheus.Registerer = r
+		if cluster != "" {
+			registerer = prometheus.WrapRegistererWith(prometheus.Labels{"cluster": cluster}, r)
+		}
+		if err := registerer.Register(nc); err != nil {
+			return nil, fmt.Errorf("couldn't register node collector: %s", err)
+		}
 	}
 
 	var handler http.Handler
@@ -167,13 +200,44 @@ func main() {
 	kingpin.Version(version.Print("slurm_exporter"))
 	kingpin.CommandLine.UsageWriter(os.Stdout)
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	kingpin.Command("serve", "Serve the metrics over HTTP.").Default()
+	recordCommand := kingpin.Command("record", "Run every enabled collector once, write their Slurm commands to --slurm.record-dir and exit.")
+	command := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
 
+	if *configFile != "" {
+		if err := collector.LoadConfig(*configFile); err != nil {
+			level.Error(logger).Log("msg", "Couldn't load config", "file", *configFile, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if command == recordCommand.FullCommand() {
+		if err := collector.RecordOnce(logger); err != nil {
+			level.Error(logger).Log("msg", "Couldn't record the Slurm commands", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	level.Info(logger).Log("msg", "Starting slurm_exporter", "version", version.Info())
 	level.Info(logger).Log("msg", "Build context", "context", version.BuildContext())
 
-	http.Handle(*metricsPath, newHandler(!*disableExporterMetrics, *maxRequests, logger))
+	metricsHandler := newHandler(!*disableExporterMetrics, *maxRequests, logger)
+	if *configFile != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := metricsHandler.reload(*configFile); err != nil {
+					level.Error(logger).Log("msg", "Couldn't reload config", "file", *configFile, "err", err)
+					continue
+				}
+				level.Info(logger).Log("msg", "Reloaded config", "file", *configFile)
+			}
+		}()
+	}
+	http.Handle(*metricsPath, metricsHandler)
 	if *metricsPath != "/" && *metricsPath != "" {
 		landingConfig := web.LandingConfig{
 			Name:        "SLURM Exporter",